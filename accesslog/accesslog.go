@@ -0,0 +1,141 @@
+// Package accesslog writes one line per completed client session in a template or JSON format.
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/z0rr0/gsocks5/server"
+)
+
+const (
+	// FormatJSON is a format name of JSON lines.
+	FormatJSON = "json"
+	// DefaultFormat is a default template of access log lines.
+	DefaultFormat = `{{.Time.Format "2006-01-02T15:04:05.000Z07:00"}} {{.Listener}} {{.Session}} {{.Client}} ` +
+		`{{or .User "-"}} {{or .Dest "-"}} {{or .IP "-"}} {{.Reply}} {{.Up}} {{.Down}} ` +
+		`{{printf "%.3f" .Duration}} {{.Reason}}`
+)
+
+// ErrFormat is returned when the access log format is invalid.
+var ErrFormat = errors.New("invalid access log format")
+
+// Entry is data of one access log line.
+type Entry struct {
+	Time     time.Time `json:"time"`       // session start time
+	Listener string    `json:"listener"`   // listener name
+	Session  string    `json:"session_id"` // session ID, it is unique for the listener
+	Client   string    `json:"client"`     // client address
+	User     string    `json:"user"`       // authenticated user, empty without authentication
+	Dest     string    `json:"dest"`       // requested destination "host:port"
+	IP       string    `json:"ip"`         // resolved destination IP address
+	Reply    int32     `json:"reply"`      // SOCKS reply code, -1 if the reply is not sent
+	Up       uint64    `json:"bytes_up"`   // bytes sent to the destination
+	Down     uint64    `json:"bytes_down"` // bytes received from the destination
+	Duration float64   `json:"duration"`   // session duration in seconds
+	Reason   string    `json:"reason"`     // session close reason
+}
+
+// newEntry returns an entry of the listener session record.
+func newEntry(listener string, r *server.AccessRecord) *Entry {
+	return &Entry{
+		Time:     r.Started,
+		Listener: listener,
+		Session:  r.ID,
+		Client:   r.Client,
+		User:     r.User,
+		Dest:     r.Destination,
+		IP:       r.IP,
+		Reply:    r.Reply,
+		Up:       r.Sent,
+		Down:     r.Received,
+		Duration: r.Duration.Seconds(),
+		Reason:   r.Reason,
+	}
+}
+
+// Logger writes access log lines, it is safe for concurrent use.
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	tmpl   *template.Template // nil for JSON format
+	buf    bytes.Buffer
+	logger *slog.Logger // write errors logger
+}
+
+// New returns a new access logger, format is FormatJSON or a text/template of Entry.
+func New(w io.Writer, format string, logger *slog.Logger) (*Logger, error) {
+	l := &Logger{w: w, logger: logger}
+	if format == FormatJSON {
+		return l, nil
+	}
+
+	tmpl, err := template.New("access").Parse(format)
+	if err != nil {
+		return nil, errors.Join(ErrFormat, err)
+	}
+
+	// the template is checked by an empty entry to fail on unknown fields at start
+	if err = tmpl.Execute(io.Discard, &Entry{}); err != nil {
+		return nil, errors.Join(ErrFormat, err)
+	}
+
+	l.tmpl = tmpl
+	return l, nil
+}
+
+// Write writes the entry as one line.
+func (l *Logger) Write(e *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Reset()
+	if err := l.format(e); err != nil {
+		return err
+	}
+
+	if b := l.buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		l.buf.WriteByte('\n')
+	}
+
+	if _, err := l.w.Write(l.buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write access log: %w", err)
+	}
+
+	return nil
+}
+
+// format writes the entry to the buffer.
+func (l *Logger) format(e *Entry) error {
+	if l.tmpl == nil {
+		// the encoder adds a new line
+		return json.NewEncoder(&l.buf).Encode(e)
+	}
+
+	return l.tmpl.Execute(&l.buf, e)
+}
+
+// Listener returns an access logger of the listener sessions.
+func (l *Logger) Listener(name string) server.AccessLogger {
+	return &listenerLogger{name: name, l: l}
+}
+
+// listenerLogger writes records of one listener sessions.
+type listenerLogger struct {
+	name string
+	l    *Logger
+}
+
+// LogAccess writes the session record, errors are logged.
+func (ll *listenerLogger) LogAccess(r *server.AccessRecord) {
+	if err := ll.l.Write(newEntry(ll.name, r)); err != nil {
+		ll.l.logger.Error("failed to write access log", "listener", ll.name, "session_id", r.ID, "error", err)
+	}
+}
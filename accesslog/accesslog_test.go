@@ -0,0 +1,118 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/gsocks5/conn"
+	"github.com/z0rr0/gsocks5/server"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
+
+func record() *server.AccessRecord {
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	return &server.AccessRecord{
+		SessionInfo: server.SessionInfo{
+			Stats:       conn.Stats{Started: started, Last: started, Received: 2048, Sent: 512},
+			ID:          "7",
+			Client:      "192.0.2.1:50000",
+			User:        "alice",
+			Destination: "example.com:443",
+			IP:          "198.51.100.1",
+		},
+		Reply:    0,
+		Duration: 1500 * time.Millisecond,
+		Reason:   server.ReasonClosed,
+	}
+}
+
+func TestLogger_Template(t *testing.T) {
+	testCases := []struct {
+		name     string
+		format   string
+		record   *server.AccessRecord
+		expected string
+	}{
+		{
+			name:   "default",
+			format: DefaultFormat,
+			record: record(),
+			expected: "2025-01-02T03:04:05.000Z main 7 192.0.2.1:50000 alice example.com:443 198.51.100.1 " +
+				"0 512 2048 1.500 closed\n",
+		},
+		{
+			name:     "empty",
+			format:   DefaultFormat,
+			record:   &server.AccessRecord{Reply: server.NoReply, Reason: server.ReasonTimeout},
+			expected: "0001-01-01T00:00:00.000Z main   - - - -1 0 0 0.000 timeout\n",
+		},
+		{
+			name:     "custom",
+			format:   "{{.User}} {{.Dest}} {{.Reply}}\n",
+			record:   record(),
+			expected: "alice example.com:443 0\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			l, err := New(&buf, tc.format, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			l.Listener("main").LogAccess(tc.record)
+			if s := buf.String(); s != tc.expected {
+				t.Errorf("unexpected line %q, expected %q", s, tc.expected)
+			}
+		})
+	}
+}
+
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, err := New(&buf, FormatJSON, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Listener("main").LogAccess(record())
+	l.Listener("main").LogAccess(record())
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if n := len(lines); n != 2 {
+		t.Fatalf("expected 2 lines, got %d", n)
+	}
+
+	var e Entry
+	if err = json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Listener != "main" || e.Session != "7" || e.User != "alice" || e.IP != "198.51.100.1" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	if e.Up != 512 || e.Down != 2048 || e.Duration != 1.5 || e.Reason != server.ReasonClosed {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, format := range []string{"{{.User", "{{.Unknown}}"} {
+		if _, err := New(&bytes.Buffer{}, format, logger); !errors.Is(err, ErrFormat) {
+			t.Errorf("format %q: expected ErrFormat, got %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/z0rr0/gsocks5/metrics"
+)
+
+const (
+	// httpRetry is a period of listening attempts, the address can be busy by the previous process after upgrade.
+	httpRetry = time.Second
+	// httpTimeout is a read header timeout of HTTP requests.
+	httpTimeout = 5 * time.Second
+)
+
+// readyCheck is a named readiness check, it returns an error if the process is not ready.
+type readyCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// newAdminServer returns HTTP server of metrics, health and readiness endpoints.
+// Sessions API is available only if it is not nil.
+func newAdminServer(addr string, registry *metrics.Registry, checks []readyCheck, api *sessionsAPI) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", registry)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintln(w, "ok") // the client has gone
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready(w, r, checks)
+	})
+	if api != nil {
+		api.register(mux)
+	}
+
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: httpTimeout}
+}
+
+// ready runs all readiness checks and replies 503 status with failed ones.
+func ready(w http.ResponseWriter, r *http.Request, checks []readyCheck) {
+	var failed []string
+
+	for _, rc := range checks {
+		if err := rc.check(r.Context()); err != nil {
+			failed = append(failed, rc.name+": "+err.Error())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failed) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = fmt.Fprintln(w, strings.Join(failed, "\n"))
+		return
+	}
+
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// listenHTTP serves HTTP requests until the server is closed.
+// Listening is retried until the context is done, because the previous process can use the address after upgrade.
+func listenHTTP(ctx context.Context, s *http.Server) {
+	for {
+		listener, err := net.Listen("tcp", s.Addr)
+		if err == nil {
+			logger.Info("admin http server", "addr", s.Addr)
+			if err = s.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("admin http server error", "error", err)
+			}
+			return
+		}
+
+		logger.Warn("failed to listen http", "addr", s.Addr, "retry", httpRetry, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(httpRetry):
+		}
+	}
+}
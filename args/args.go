@@ -29,6 +29,33 @@ func IsFile(value string, result *string) error {
 	return nil
 }
 
+// AppendFile checks that the value is a file and appends it to the result.
+func AppendFile(value string, result *[]string) error {
+	var fileName string
+
+	if err := IsFile(value, &fileName); err != nil {
+		return err
+	}
+
+	*result = append(*result, fileName)
+	return nil
+}
+
+// IsFileMode checks that the value is an octal file permissions mode.
+func IsFileMode(value string, result *os.FileMode) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return err
+	}
+
+	if mode == 0 || mode > uint64(os.ModePerm) {
+		return fmt.Errorf("file mode is out of range")
+	}
+
+	*result = os.FileMode(mode)
+	return nil
+}
+
 // IsPort checks that the value is a valid port number.
 func IsPort(value string, result *uint16) error {
 	port, err := strconv.ParseUint(value, 10, 16)
@@ -1,6 +1,7 @@
 package args
 
 import (
+	"errors"
 	"os"
 	"testing"
 )
@@ -53,6 +54,34 @@ func TestIsFile(t *testing.T) {
 	}
 }
 
+func TestAppendFile(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %v", err)
+	}
+
+	defer func() {
+		if e := errors.Join(tempFile.Close(), os.Remove(tempFile.Name())); e != nil {
+			t.Fatalf("failed to close or remove temporary file: %v", e)
+		}
+	}()
+
+	var result []string
+	for range 2 {
+		if err = AppendFile(tempFile.Name(), &result); err != nil {
+			t.Fatalf("AppendFile() unexpected error: %v", err)
+		}
+	}
+
+	if err = AppendFile(os.TempDir(), &result); err == nil {
+		t.Error("AppendFile() expected error for directory")
+	}
+
+	if n := len(result); n != 2 {
+		t.Errorf("AppendFile() = %d files, want 2", n)
+	}
+}
+
 func TestIsPort(t *testing.T) {
 	testCases := []struct {
 		name    string
@@ -85,6 +114,39 @@ func TestIsPort(t *testing.T) {
 	}
 }
 
+func TestIsFileMode(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{name: "ValidMode", value: "0660", want: 0o660},
+		{name: "ShortMode", value: "600", want: 0o600},
+		{name: "ZeroMode", value: "0", wantErr: true},
+		{name: "TooHighMode", value: "1777", wantErr: true},
+		{name: "NonOctalMode", value: "0668", wantErr: true},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			var result os.FileMode
+
+			err := IsFileMode(tc.value, &result)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("IsFileMode() error = %v, wantErr %v", err, tc.wantErr)
+				return
+			}
+
+			if result != tc.want {
+				t.Errorf("IsFileMode() = %v, want %v", result, tc.want)
+			}
+		})
+	}
+}
+
 func TestIsConcurrent(t *testing.T) {
 	testCases := []struct {
 		name    string
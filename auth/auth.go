@@ -2,9 +2,11 @@ package auth
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -33,20 +35,29 @@ func (u usersData) String() string {
 	return strings.TrimRight(b.String(), sep)
 }
 
+// Valid returns true if the user exists and the password is correct.
+// Passwords are compared in constant time by their hashes, so the response time does not disclose them.
+func (u usersData) Valid(user, password string) bool {
+	expected, ok := u[user]
+	a, b := sha256.Sum256([]byte(expected)), sha256.Sum256([]byte(password))
+
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1 && ok
+}
+
 // New returns a new credential store.
-func New(fileName string, logger *log.Logger) (socks5.CredentialStore, error) {
+func New(fileName string, logger *slog.Logger) (socks5.CredentialStore, error) {
 	users, err := parseFile(fileName)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(users) == 0 {
-		logger.Println("no credentials found")
+		logger.Warn("no credentials found", "file", fileName)
 		return nil, nil
 	}
 
-	logger.Printf("found credentials for users: %s", users.String())
-	return socks5.StaticCredentials(users), nil
+	logger.Info("found credentials", "file", fileName, "users", users.String())
+	return users, nil
 }
 
 // parseFile reads the given file and returns a map of username/password pairs.
@@ -1,14 +1,12 @@
 package auth
 
 import (
-	"log"
+	"log/slog"
 	"os"
 	"testing"
-
-	"github.com/armon/go-socks5"
 )
 
-var logger = log.New(os.Stdout, "[test] ", log.LstdFlags|log.Lshortfile)
+var logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
 
 func userFile(rows []string) (string, error) {
 	f, err := os.CreateTemp("", "users_gsocks5_test")
@@ -95,7 +93,7 @@ func TestNew(t *testing.T) {
 				return
 			}
 			// no error, values is to be non-nil
-			values, ok := credentials.(socks5.StaticCredentials)
+			values, ok := credentials.(usersData)
 			if !ok {
 				tt.Fatalf("unexpected credentials type: %T", credentials)
 			}
@@ -110,3 +108,25 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestUsersData_Valid(t *testing.T) {
+	users := usersData{"alice": "secret"}
+
+	testCases := []struct {
+		user, password string
+		valid          bool
+	}{
+		{user: "alice", password: "secret", valid: true},
+		{user: "alice", password: "secret1"},
+		{user: "alice", password: "Secret"},
+		{user: "alice"},
+		{user: "bob", password: "secret"},
+		{user: "bob"},
+	}
+
+	for _, tc := range testCases {
+		if valid := users.Valid(tc.user, tc.password); valid != tc.valid {
+			t.Errorf("Valid(%q, %q) = %v", tc.user, tc.password, valid)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/user"
+	"strconv"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+// ErrPeersFile is returned when the peer users file content is invalid.
+var ErrPeersFile = errors.New("invalid peer users file")
+
+// Peers returns users of unix socket clients by their UID from the file.
+// Every line is a local user name or UID and a proxy user name, for example "1000 alice".
+func Peers(fileName string, logger *slog.Logger) (map[uint32]string, error) {
+	if fileName == "" {
+		return nil, nil
+	}
+
+	peers := make(map[uint32]string)
+	if err := lines.Read(fileName, parsePeers(peers)); err != nil {
+		return nil, errors.Join(ErrPeersFile, err)
+	}
+
+	logger.Info("found peer users", "file", fileName, "users", len(peers))
+	return peers, nil
+}
+
+// parsePeers returns a parser of lines "UID|name user" which adds users of UIDs to the peers.
+func parsePeers(peers map[uint32]string) func([]string) error {
+	return func(values []string) error {
+		if len(values) != 2 {
+			return errors.New("expected UID and user name")
+		}
+
+		uid, err := lookupUID(values[0])
+		if err != nil {
+			return err
+		}
+
+		peers[uid] = values[1]
+		return nil
+	}
+}
+
+// lookupUID returns UID of the local user name or the numeric UID itself.
+func lookupUID(value string) (uint32, error) {
+	if uid, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+
+	u, err := user.Lookup(value)
+	if err != nil {
+		return 0, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid UID %q of user %q: %w", u.Uid, value, err)
+	}
+
+	return uint32(uid), nil
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+func TestParsePeers(t *testing.T) {
+	peers := make(map[uint32]string)
+	err := lines.Scan(strings.NewReader("# uid user\n1000 alice\n\nroot admin\n1001  bob\n"), parsePeers(peers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[uint32]string{1000: "alice", 0: "admin", 1001: "bob"}
+	if len(peers) != len(expected) {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+
+	for uid, name := range expected {
+		if peers[uid] != name {
+			t.Errorf("unexpected user %q for UID %d", peers[uid], uid)
+		}
+	}
+
+	for _, content := range []string{"1000\n", "1000 alice extra\n", "unknown-local-user alice\n"} {
+		if err = lines.Scan(strings.NewReader(content), parsePeers(make(map[uint32]string))); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+}
+
+func TestPeers(t *testing.T) {
+	peers, err := Peers("", logger)
+	if err != nil || peers != nil {
+		t.Errorf("unexpected result %v, %v", peers, err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "peers.txt")
+	if err = os.WriteFile(fileName, []byte("1000 alice\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if peers, err = Peers(fileName, logger); err != nil || peers[1000] != "alice" {
+		t.Errorf("unexpected result %v, %v", peers, err)
+	}
+
+	if err = os.WriteFile(fileName, []byte("bad\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Peers(fileName, logger); !errors.Is(err, ErrPeersFile) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/args"
+	"github.com/z0rr0/gsocks5/quota"
+	"github.com/z0rr0/gsocks5/server"
+)
+
+const (
+	// quotaCommand is a subcommand to show users' traffic.
+	quotaCommand = "quota"
+	// healthCommand is a subcommand to check the local server by SOCKS5 handshake.
+	healthCommand = "healthcheck"
+)
+
+// quotaReport prints users' traffic from the state file.
+func quotaReport(arguments []string) error {
+	var (
+		stateFile  string
+		quotasFile string
+		fs         = flag.NewFlagSet(quotaCommand, flag.ExitOnError)
+	)
+
+	fs.Func("state", "traffic accounting state file", func(s string) error { return args.IsFile(s, &stateFile) })
+	fs.Func("quotas", "users traffic quotas file", func(s string) error { return args.IsFile(s, &quotasFile) })
+
+	if err := fs.Parse(arguments); err != nil {
+		return err
+	}
+
+	if stateFile == "" {
+		return fmt.Errorf("state file is required")
+	}
+
+	return quota.Report(os.Stdout, stateFile, quotasFile)
+}
+
+// healthCheck connects to the server and performs SOCKS5 handshake,
+// the connection to the target is also checked if it is set.
+func healthCheck(arguments []string) error {
+	var (
+		addr     = "127.0.0.1:1080"
+		user     string
+		password string
+		target   string
+		timeout  = 5 * time.Second
+		fs       = flag.NewFlagSet(healthCommand, flag.ExitOnError)
+	)
+
+	fs.StringVar(&addr, "addr", addr, "server address or unix socket path with \""+server.UnixPrefix+"\" prefix")
+	fs.StringVar(&user, "user", "", "user name, only server reply is checked without it")
+	fs.StringVar(&password, "password", "", "user password")
+	fs.StringVar(&target, "target", "", "destination host:port to connect via the server")
+	fs.DurationVar(&timeout, "timeout", timeout, "check timeout")
+
+	if err := fs.Parse(arguments); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if target == "" {
+		return server.Check(ctx, addr, user, password)
+	}
+
+	return connectTarget(ctx, addr, user, password, target)
+}
+
+// connectTarget connects to the target via the server.
+func connectTarget(ctx context.Context, addr, user, password, target string) error {
+	var auth *proxy.Auth
+	if user != "" {
+		auth = &proxy.Auth{User: user, Password: password}
+	}
+
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, server.UnixPrefix); ok {
+		network, addr = "unix", path
+	}
+
+	dialer, err := proxy.SOCKS5(network, addr, auth, proxy.Direct)
+	if err != nil {
+		return errors.Join(server.ErrHealth, err)
+	}
+
+	cd, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return errors.Join(server.ErrHealth, errors.New("dialer without context"))
+	}
+
+	c, err := cd.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return errors.Join(server.ErrHealth, err)
+	}
+
+	return c.Close()
+}
@@ -4,23 +4,35 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"time"
+
+	"github.com/z0rr0/gsocks5/limit"
 )
 
 // DialType is a dial function type alias.
 type DialType = func(ctx context.Context, network, addr string) (net.Conn, error)
 
+// CloseWrite shuts down the writing side of the connection if it is supported.
+// Connection wrappers use it to pass a half-close to the wrapped connection.
+func CloseWrite(c net.Conn) error {
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
 // idleTimeoutConn is a net.Conn wrapper with idle timeout.
 type idleTimeoutConn struct {
 	net.Conn
 	timeout time.Duration
-	logger  *log.Logger
+	logger  *slog.Logger
 }
 
 // newIdleTimeoutConn creates a new idleTimeoutConn.
-func newIdleTimeoutConn(conn net.Conn, timeout time.Duration, logger *log.Logger) *idleTimeoutConn {
+func newIdleTimeoutConn(conn net.Conn, timeout time.Duration, logger *slog.Logger) *idleTimeoutConn {
 	return &idleTimeoutConn{Conn: conn, timeout: timeout, logger: logger}
 }
 
@@ -31,17 +43,22 @@ func (c *idleTimeoutConn) Read(b []byte) (int, error) {
 
 	if err != nil {
 		if errors.As(err, &netErr) && netErr.Timeout() {
-			c.logger.Printf("idleTimeoutConn read timeout from %s to %s", c.Conn.RemoteAddr(), c.Conn.LocalAddr())
+			c.logger.Debug("idle read timeout", "remote", c.Conn.RemoteAddr().String(), "local", c.Conn.LocalAddr().String())
 		}
 	} else if c.timeout > 0 {
 		if deadlineErr := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); deadlineErr != nil {
-			c.logger.Printf("idleTimeoutConn failed to set read deadline: %v", deadlineErr)
+			c.logger.Warn("failed to set read deadline", "error", deadlineErr)
 		}
 	}
 
 	return n, err
 }
 
+// CloseWrite shuts down the writing side of the connection.
+func (c *idleTimeoutConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
 // Write writes data to the connection.
 func (c *idleTimeoutConn) Write(b []byte) (int, error) {
 	var netErr net.Error
@@ -49,25 +66,183 @@ func (c *idleTimeoutConn) Write(b []byte) (int, error) {
 
 	if err != nil {
 		if errors.As(err, &netErr) && netErr.Timeout() {
-			c.logger.Printf("idleTimeoutConn write timeout from %s to %s", c.Conn.RemoteAddr(), c.Conn.LocalAddr())
+			c.logger.Debug("idle write timeout", "remote", c.Conn.RemoteAddr().String(), "local", c.Conn.LocalAddr().String())
 		}
 	} else if c.timeout > 0 {
 		if deadlineErr := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); deadlineErr != nil {
-			c.logger.Printf("idleTimeoutConn failed to set write deadline: %v", deadlineErr)
+			c.logger.Warn("failed to set write deadline", "error", deadlineErr)
 		}
 	}
 
 	return n, err
 }
 
+// Forwarder selects a parent proxy dial function for the request.
+type Forwarder interface {
+	// Forward returns a dial function for the destination address or nil for a direct connection.
+	Forward(ctx context.Context, addr string) DialType
+}
+
+// DialObserver observes upstream connections, it is used for metrics.
+type DialObserver interface {
+	// ObserveDial is called after every dial attempt with its duration and error.
+	ObserveDial(d time.Duration, err error)
+}
+
+// Option is an optional Dial setting.
+type Option func(*options)
+
+// options are optional Dial settings.
+type options struct {
+	sources   *SourcePool
+	forwarder Forwarder
+	limiter   *limit.Limiter
+	meters    meters
+	headers   *ProxyHeaders
+	observer  DialObserver
+}
+
+// WithSources sets a pool of outbound source addresses.
+func WithSources(sp *SourcePool) Option {
+	return func(o *options) {
+		o.sources = sp
+	}
+}
+
+// WithForwarder sets a parent proxy selector.
+// Source addresses are applied only to direct connections.
+func WithForwarder(f Forwarder) Option {
+	return func(o *options) {
+		o.forwarder = f
+	}
+}
+
+// WithLimiter sets a bandwidth limiter of upstream connections.
+func WithLimiter(l *limit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// WithMeter adds a traffic meter of users, all added meters count the same traffic.
+func WithMeter(m Meter) Option {
+	return func(o *options) {
+		o.meters = append(o.meters, m)
+	}
+}
+
+// WithDialObserver sets an observer of upstream connections.
+func WithDialObserver(do DialObserver) Option {
+	return func(o *options) {
+		o.observer = do
+	}
+}
+
+// WithProxyHeaders sets destinations of upstream connections which get PROXY protocol header
+// with the client address before its data.
+func WithProxyHeaders(ph *ProxyHeaders) Option {
+	return func(o *options) {
+		o.headers = ph
+	}
+}
+
 // Dial creates a new DialType.
-func Dial(dialer *net.Dialer, timeout time.Duration, logger *log.Logger) DialType {
+// The timeout is an idle timeout of the upstream connection if the request has no session,
+// otherwise the session timers are used.
+func Dial(dialer *net.Dialer, timeout time.Duration, logger *slog.Logger, opts ...Option) DialType {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	return func(ctx context.Context, network, addr string) (net.Conn, error) {
-		connection, err := dialer.DialContext(ctx, network, addr)
+		started := time.Now()
+		connection, err := o.dial(ctx, dialer, network, addr)
+		if o.observer != nil {
+			o.observer.ObserveDial(time.Since(started), err)
+		}
+
 		if err != nil {
-			return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+			return nil, err
+		}
+
+		req := RequestFrom(ctx)
+		if err = o.writeHeader(connection, req, addr); err != nil {
+			return nil, errors.Join(err, connection.Close())
+		}
+
+		user := req.User
+		if o.limiter != nil {
+			down, up := o.limiter.Session(user)
+			connection = newThrottledConn(connection, down, up)
+		}
+
+		if len(o.meters) > 0 && user != "" {
+			connection = newMeteredConn(connection, user, o.meters)
 		}
 
-		return newIdleTimeoutConn(connection, timeout, logger), nil
+		if req.Session == nil {
+			return newIdleTimeoutConn(connection, timeout, logger), nil
+		}
+
+		// the session watches idle timeout of both client and upstream connections
+		connection = req.Session.TrackUpstream(connection)
+		if err = req.Session.Start(); err != nil {
+			logger.Warn(
+				"failed to start session timers",
+				"client", req.Client, "user", user, "dest", addr, "error", err,
+			)
+		}
+
+		return connection, nil
 	}
 }
+
+// dial connects to the address directly or through a parent proxy.
+func (o *options) dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	if o.forwarder != nil {
+		if forward := o.forwarder.Forward(ctx, addr); forward != nil {
+			connection, err := forward(ctx, network, addr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to dial %s via upstream: %w", addr, err)
+			}
+
+			return connection, nil
+		}
+	}
+
+	d := dialer
+	if o.sources != nil {
+		if ip := o.sources.Select(RequestFrom(ctx).User, addr); ip != nil {
+			local := *dialer
+			local.LocalAddr = &net.TCPAddr{IP: ip}
+			d = &local
+		}
+	}
+
+	connection, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+
+	return connection, nil
+}
+
+// writeHeader sends PROXY protocol header to the upstream connection if its destination matches the rules.
+// The header is not counted as the user traffic.
+func (o *options) writeHeader(connection net.Conn, req *Request, addr string) error {
+	if o.headers == nil {
+		return nil
+	}
+
+	header := o.headers.Header(req, addr, connection.RemoteAddr())
+	if header == nil {
+		return nil
+	}
+
+	if _, err := connection.Write(header.Bytes()); err != nil {
+		return fmt.Errorf("failed to write PROXY protocol header to %s: %w", addr, err)
+	}
+
+	return nil
+}
@@ -2,7 +2,8 @@ package conn
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net"
 	"os"
 	"testing"
@@ -11,7 +12,7 @@ import (
 
 const timeout = 3 * time.Second
 
-var logger = log.New(os.Stdout, "[test] ", log.LstdFlags|log.Lshortfile|log.Lmicroseconds)
+var logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
 
 // testConn is a test net.Conn implementation.
 type testConn struct {
@@ -102,3 +103,47 @@ func TestDial(t *testing.T) {
 		t.Errorf("unexpected connection type [%T]", connection)
 	}
 }
+
+// testObserver is a test DialObserver implementation.
+type testObserver struct {
+	calls int
+	err   error
+}
+
+func (o *testObserver) ObserveDial(_ time.Duration, err error) {
+	o.calls++
+	o.err = err
+}
+
+func TestDial_Observer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().String()
+	observer := &testObserver{}
+	connFunc := Dial(&net.Dialer{Timeout: timeout}, timeout, logger, WithDialObserver(observer))
+
+	connection, err := connFunc(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err = errors.Join(connection.Close(), listener.Close()); err != nil {
+		t.Fatal(err)
+	}
+
+	if observer.calls != 1 || observer.err != nil {
+		t.Errorf("unexpected observer state: %+v", observer)
+	}
+
+	// the listener is closed
+	if _, err = connFunc(context.Background(), "tcp", addr); err == nil {
+		t.Fatal("expected dial error")
+	}
+
+	if observer.calls != 2 || observer.err == nil {
+		t.Errorf("unexpected observer state: %+v", observer)
+	}
+}
@@ -0,0 +1,27 @@
+package conn
+
+import "context"
+
+// requestKey is a context key for Request.
+type requestKey struct{}
+
+// Request is a client request metadata that is passed to the dialer by the context.
+type Request struct {
+	User    string   // authenticated user name, it's empty without authentication
+	Client  string   // client address
+	FQDN    string   // requested domain name, it's empty if the client requested an IP address
+	Session *Session // client session, it's nil if the server does not watch sessions
+}
+
+// WithRequest returns a copy of the context with the request metadata.
+func WithRequest(ctx context.Context, r *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// RequestFrom returns the request metadata from the context or an empty request.
+func RequestFrom(ctx context.Context) *Request {
+	if r, ok := ctx.Value(requestKey{}).(*Request); ok {
+		return r
+	}
+	return &Request{}
+}
@@ -0,0 +1,83 @@
+package conn
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// Meter counts users' transferred bytes.
+type Meter interface {
+	// Count adds bytes received from (in) and sent to (out) the destination,
+	// an error means that the session should be closed.
+	Count(user string, in, out int) error
+}
+
+// meters are several meters of the same traffic.
+type meters []Meter
+
+// Count counts bytes by all meters, an error of any meter is returned.
+func (ms meters) Count(user string, in, out int) error {
+	var errs []error
+
+	for _, m := range ms {
+		if err := m.Count(user, in, out); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// meteredConn is a net.Conn wrapper that counts transferred bytes of the user.
+type meteredConn struct {
+	net.Conn
+	user  string
+	meter Meter
+	once  sync.Once
+	err   error
+}
+
+// newMeteredConn returns the connection that counts traffic of the user.
+func newMeteredConn(conn net.Conn, user string, meter Meter) *meteredConn {
+	return &meteredConn{Conn: conn, user: user, meter: meter}
+}
+
+// stop closes the connection once after the meter error.
+func (c *meteredConn) stop(err error) error {
+	c.once.Do(func() {
+		c.err = errors.Join(err, c.Conn.Close())
+	})
+	return c.err
+}
+
+// Read reads data from the connection and counts it.
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	if n > 0 {
+		if meterErr := c.meter.Count(c.user, n, 0); meterErr != nil {
+			return n, c.stop(meterErr)
+		}
+	}
+
+	return n, err
+}
+
+// CloseWrite shuts down the writing side of the connection.
+func (c *meteredConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+// Write writes data to the connection and counts it.
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	if n > 0 {
+		if meterErr := c.meter.Count(c.user, 0, n); meterErr != nil {
+			return n, c.stop(meterErr)
+		}
+	}
+
+	return n, err
+}
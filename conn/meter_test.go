@@ -0,0 +1,71 @@
+package conn
+
+import (
+	"errors"
+	"testing"
+)
+
+var errMeter = errors.New("meter error")
+
+// testMeter is a test Meter implementation with a bytes limit.
+type testMeter struct {
+	in, out int
+	limit   int
+}
+
+func (m *testMeter) Count(_ string, in, out int) error {
+	m.in += in
+	m.out += out
+
+	if m.in+m.out > m.limit {
+		return errMeter
+	}
+	return nil
+}
+
+func TestMeteredConn(t *testing.T) {
+	var (
+		meter = &testMeter{limit: 15}
+		c     = newMeteredConn(&countConn{}, "alice", meter)
+	)
+
+	if n, err := c.Read(make([]byte, 10)); n != 10 || err != nil {
+		t.Errorf("unexpected read result: %d, %v", n, err)
+	}
+
+	if n, err := c.Write(make([]byte, 5)); n != 5 || err != nil {
+		t.Errorf("unexpected write result: %d, %v", n, err)
+	}
+
+	if meter.in != 10 || meter.out != 5 {
+		t.Errorf("unexpected counters: %d/%d", meter.in, meter.out)
+	}
+
+	if _, err := c.Write(make([]byte, 1)); !errors.Is(err, errMeter) {
+		t.Errorf("expected meter error, got %v", err)
+	}
+
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, errMeter) {
+		t.Errorf("expected meter error, got %v", err)
+	}
+}
+
+func TestMeters(t *testing.T) {
+	var (
+		first  = &testMeter{limit: 100}
+		second = &testMeter{limit: 10}
+		m      = meters{first, second}
+	)
+
+	if err := m.Count("alice", 5, 3); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := m.Count("alice", 5, 0); !errors.Is(err, errMeter) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if first.in != 10 || first.out != 3 || second.in != 10 || second.out != 3 {
+		t.Errorf("unexpected counters: %+v, %+v", first, second)
+	}
+}
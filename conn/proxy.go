@@ -0,0 +1,122 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/z0rr0/gsocks5/internal/domain"
+	"github.com/z0rr0/gsocks5/internal/lines"
+	"github.com/z0rr0/gsocks5/proxyproto"
+)
+
+// ErrProxyHeaders is returned when the outbound PROXY protocol configuration is invalid.
+var ErrProxyHeaders = errors.New("invalid outbound PROXY protocol config")
+
+// headerRule is a destination pattern of PROXY protocol header, only one of match fields is set.
+type headerRule struct {
+	version int
+	suffix  string
+	network *net.IPNet
+	port    uint16
+}
+
+// match returns true if the request or destination address matches the rule.
+func (r *headerRule) match(req *Request, dst netip.AddrPort) bool {
+	switch {
+	case r.suffix != "":
+		return req.FQDN != "" && domain.MatchSuffix(domain.Normalize(req.FQDN), r.suffix)
+	case r.network != nil:
+		return dst.IsValid() && r.network.Contains(dst.Addr().Unmap().AsSlice())
+	default:
+		return dst.Port() == r.port
+	}
+}
+
+// ProxyHeaders selects upstream connections which get PROXY protocol header with the client address.
+type ProxyHeaders struct {
+	rules []headerRule
+}
+
+// NewProxyHeaders returns a new ProxyHeaders from the file with lines "v1|v2 domain|cidr|port VALUE".
+// The first matched line sets the header version.
+func NewProxyHeaders(fileName string) (*ProxyHeaders, error) {
+	rules, err := lines.ReadAll(fileName, parseHeaderRule)
+	if err != nil {
+		return nil, errors.Join(ErrProxyHeaders, err)
+	}
+
+	return &ProxyHeaders{rules: rules}, nil
+}
+
+// parseHeaderRule parses "v1|v2 domain|cidr|port VALUE" fields.
+func parseHeaderRule(values []string) (headerRule, error) {
+	var rule headerRule
+
+	if len(values) != 3 {
+		return rule, fmt.Errorf("expected version, rule type and value, got %q", strings.Join(values, " "))
+	}
+
+	switch values[0] {
+	case "v1":
+		rule.version = 1
+	case "v2":
+		rule.version = 2
+	default:
+		return rule, fmt.Errorf("unknown PROXY protocol version %q", values[0])
+	}
+
+	switch kind, value := values[1], values[2]; kind {
+	case "domain":
+		rule.suffix = domain.ParseSuffix(value)
+		if rule.suffix == "" {
+			return rule, errors.New("empty domain suffix")
+		}
+	case "cidr":
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return rule, err
+		}
+		rule.network = network
+	case "port":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || port == 0 {
+			return rule, fmt.Errorf("invalid port %q", value)
+		}
+		rule.port = uint16(port)
+	default:
+		return rule, fmt.Errorf("unknown rule type %q", kind)
+	}
+
+	return rule, nil
+}
+
+// Header returns PROXY protocol header for the request to the destination address "host:port"
+// or nil if no rule matches. The header has no addresses if the client address is unknown.
+func (ph *ProxyHeaders) Header(req *Request, addr string, remote net.Addr) *proxyproto.Header {
+	dst, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		// the address is a host name or the connection goes via a parent proxy
+		if ta, ok := remote.(*net.TCPAddr); ok {
+			dst = ta.AddrPort()
+		}
+	}
+
+	for i := range ph.rules {
+		if rule := &ph.rules[i]; rule.match(req, dst) {
+			header := &proxyproto.Header{Version: rule.version}
+
+			if src, srcErr := netip.ParseAddrPort(req.Client); srcErr == nil && dst.IsValid() {
+				header.Source = net.TCPAddrFromAddrPort(src)
+				header.Destination = net.TCPAddrFromAddrPort(dst)
+			}
+
+			return header
+		}
+	}
+
+	return nil
+}
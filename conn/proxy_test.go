@@ -0,0 +1,194 @@
+package conn
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/gsocks5/proxyproto"
+)
+
+// proxyHeaders returns ProxyHeaders from the configuration text.
+func proxyHeaders(t *testing.T, text string) *ProxyHeaders {
+	f, err := os.CreateTemp("", "proxy_headers_gsocks5_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if e := os.Remove(f.Name()); e != nil {
+			t.Error(e)
+		}
+	})
+
+	if _, err = f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ph, err := NewProxyHeaders(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ph
+}
+
+func TestNewProxyHeaders(t *testing.T) {
+	if _, err := NewProxyHeaders("/not/existing/file"); !errors.Is(err, ErrProxyHeaders) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := []string{
+		"v3 port 80",
+		"v1 port",
+		"v1 port 0",
+		"v1 port http",
+		"v2 cidr 10.0.0.0/33",
+		"v2 domain *.",
+		"v2 user alice",
+	}
+
+	for _, line := range invalid {
+		if _, err := parseHeaderRule(strings.Fields(line)); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestProxyHeaders_Header(t *testing.T) {
+	ph := proxyHeaders(t, "# backends\nv2 domain *.example.com\nv1 cidr 10.0.0.0/8 # comment\n\nv2 port 8080\n")
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 10), Port: 443}
+
+	testCases := []struct {
+		name        string
+		req         Request
+		addr        string
+		version     int
+		destination string
+	}{
+		{
+			name:    "domain",
+			req:     Request{Client: "198.51.100.1:5000", FQDN: "api.example.com"},
+			addr:    "203.0.113.1:443",
+			version: 2, destination: "203.0.113.1:443",
+		},
+		{
+			name:    "cidr",
+			req:     Request{Client: "198.51.100.1:5000"},
+			addr:    "10.1.2.3:80",
+			version: 1, destination: "10.1.2.3:80",
+		},
+		{
+			name:    "port",
+			req:     Request{Client: "198.51.100.1:5000"},
+			addr:    "203.0.113.1:8080",
+			version: 2, destination: "203.0.113.1:8080",
+		},
+		{
+			name:    "hostname",
+			req:     Request{Client: "198.51.100.1:5000", FQDN: "example.com"},
+			addr:    "example.com:443",
+			version: 2, destination: "192.0.2.10:443",
+		},
+		{name: "unknownClient", req: Request{}, addr: "10.1.2.3:80", version: 1},
+		{name: "noMatch", req: Request{Client: "198.51.100.1:5000", FQDN: "example.org"}, addr: "203.0.113.1:443"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := ph.Header(&tc.req, tc.addr, remote)
+			if tc.version == 0 {
+				if header != nil {
+					t.Errorf("unexpected header %+v", header)
+				}
+				return
+			}
+
+			if header == nil {
+				t.Fatal("expected header")
+			}
+
+			if header.Version != tc.version {
+				t.Errorf("unexpected version %d", header.Version)
+			}
+
+			if tc.destination == "" {
+				if header.Source != nil || header.Destination != nil {
+					t.Errorf("unexpected addresses %v, %v", header.Source, header.Destination)
+				}
+				return
+			}
+
+			if s := header.Source.String(); s != tc.req.Client {
+				t.Errorf("unexpected source %s", s)
+			}
+
+			if d := header.Destination.String(); d != tc.destination {
+				t.Errorf("unexpected destination %s", d)
+			}
+		})
+	}
+}
+
+func TestDial_ProxyHeaders(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := listener.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	headers := make(chan *proxyproto.Header, 1)
+	go func() {
+		c, e := listener.Accept()
+		if e != nil {
+			t.Error(e)
+			close(headers)
+			return
+		}
+		defer func() { _ = c.Close() }()
+
+		header, e := proxyproto.Read(bufio.NewReader(c))
+		if e != nil {
+			t.Error(e)
+		}
+		headers <- header
+	}()
+
+	ph := proxyHeaders(t, "v1 cidr 127.0.0.0/8\n")
+	connFunc := Dial(&net.Dialer{Timeout: timeout}, timeout, logger, WithProxyHeaders(ph))
+	ctx := WithRequest(context.Background(), &Request{Client: "192.0.2.1:12345"})
+
+	addr := listener.Addr().String()
+	connection, err := connFunc(ctx, "tcp", addr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		if e := connection.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	header := <-headers
+	if header == nil {
+		t.Fatal("header is not received")
+	}
+
+	if s := header.Source.String(); s != "192.0.2.1:12345" {
+		t.Errorf("unexpected source %s", s)
+	}
+
+	if d := header.Destination.String(); d != addr {
+		t.Errorf("unexpected destination %s", d)
+	}
+}
@@ -0,0 +1,259 @@
+package conn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session close reasons.
+const (
+	ReasonIdle     = "idle timeout"
+	ReasonLifetime = "lifetime"
+	ReasonShutdown = "shutdown"
+	ReasonKilled   = "killed by admin"
+)
+
+// Stats are session activity statistics.
+type Stats struct {
+	Started  time.Time // session start time
+	Last     time.Time // last transfer time
+	Received uint64    // bytes received from the upstream
+	Sent     uint64    // bytes sent to the upstream
+}
+
+// Session watches client and upstream connections of one client session,
+// it closes them when the session is idle or its lifetime is over.
+type Session struct {
+	mu       sync.Mutex
+	conns    []net.Conn
+	timeouts Timeouts
+	started  time.Time
+	last     atomic.Int64 // unix nanoseconds of the last transfer
+	received atomic.Uint64
+	sent     atomic.Uint64
+	timer    *time.Timer
+	finished bool
+	reason   string
+}
+
+// NewSession returns a new session with the timeouts, it starts now.
+func NewSession(timeouts Timeouts) *Session {
+	s := &Session{timeouts: timeouts, started: time.Now()}
+	s.last.Store(s.started.UnixNano())
+	return s
+}
+
+// Track returns the connection wrapper which transfers are the session activity.
+// The connection is closed with the session.
+func (s *Session) Track(c net.Conn) net.Conn {
+	return s.track(c, false)
+}
+
+// TrackUpstream is Track for the upstream connection, its transferred bytes are counted in the session stats.
+func (s *Session) TrackUpstream(c net.Conn) net.Conn {
+	return s.track(c, true)
+}
+
+// track adds the connection to the session.
+func (s *Session) track(c net.Conn, upstream bool) net.Conn {
+	s.mu.Lock()
+	s.conns = append(s.conns, c)
+	s.mu.Unlock()
+
+	return &activeConn{Conn: c, session: s, upstream: upstream}
+}
+
+// Stats returns the session statistics.
+func (s *Session) Stats() Stats {
+	return Stats{
+		Started:  s.started,
+		Last:     time.Unix(0, s.last.Load()),
+		Received: s.received.Load(),
+		Sent:     s.sent.Load(),
+	}
+}
+
+// Timeouts returns the session timeouts.
+func (s *Session) Timeouts() Timeouts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.timeouts
+}
+
+// SetTimeouts replaces the session timeouts, for example, by user's ones after authentication.
+// Handshake deadline of tracked connections is counted from the session start.
+func (s *Session) SetTimeouts(t Timeouts) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timeouts = t
+	if t.Handshake <= 0 {
+		return nil
+	}
+
+	var (
+		err      error
+		deadline = s.started.Add(t.Handshake)
+	)
+	for _, c := range s.conns {
+		err = errors.Join(err, c.SetReadDeadline(deadline))
+	}
+
+	return err
+}
+
+// Start finishes the handshake, it clears deadlines of tracked connections and starts idle and lifetime timers.
+func (s *Session) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for _, c := range s.conns {
+		err = errors.Join(err, c.SetDeadline(time.Time{}))
+	}
+
+	if !s.finished && (s.timeouts.Idle > 0 || s.timeouts.Lifetime > 0) {
+		s.timer = time.AfterFunc(s.wait(time.Now()), s.check)
+	}
+
+	return err
+}
+
+// wait returns a duration before the next check of timers.
+func (s *Session) wait(now time.Time) time.Duration {
+	var d time.Duration
+
+	if s.timeouts.Idle > 0 {
+		d = time.Unix(0, s.last.Load()).Add(s.timeouts.Idle).Sub(now)
+	}
+
+	if s.timeouts.Lifetime > 0 {
+		if lifetime := s.started.Add(s.timeouts.Lifetime).Sub(now); d == 0 || lifetime < d {
+			d = lifetime
+		}
+	}
+
+	return d
+}
+
+// expired returns a reason if any timer is over.
+func (s *Session) expired(now time.Time) string {
+	switch {
+	case s.timeouts.Lifetime > 0 && now.Sub(s.started) >= s.timeouts.Lifetime:
+		return ReasonLifetime
+	case s.timeouts.Idle > 0 && now.Sub(time.Unix(0, s.last.Load())) >= s.timeouts.Idle:
+		return ReasonIdle
+	default:
+		return ""
+	}
+}
+
+// check closes the session if it is expired or schedules the next check.
+func (s *Session) check() {
+	now := time.Now()
+	s.mu.Lock()
+
+	if s.finished {
+		s.mu.Unlock()
+		return
+	}
+
+	reason := s.expired(now)
+	if reason == "" {
+		s.timer.Reset(s.wait(now))
+		s.mu.Unlock()
+		return
+	}
+
+	s.mu.Unlock()
+	_ = s.Close(reason) // connections errors are handled by their users
+}
+
+// Close closes all tracked connections with the reason, only the first call has effect.
+func (s *Session) Close(reason string) error {
+	s.mu.Lock()
+	if s.finished {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.finished, s.reason = true, reason
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	conns := s.conns
+	s.mu.Unlock()
+
+	var err error
+	for _, c := range conns {
+		if closeErr := c.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
+			err = errors.Join(err, closeErr)
+		}
+	}
+
+	return err
+}
+
+// Stop stops timers of the finished session.
+func (s *Session) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.finished = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// Reason returns a reason why the session was closed by Close or an empty string.
+func (s *Session) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.reason
+}
+
+// activeConn is a net.Conn wrapper that marks the session as active on every transfer.
+type activeConn struct {
+	net.Conn
+	session  *Session
+	upstream bool // transferred bytes are counted
+}
+
+// Read reads data from the connection and updates the session activity.
+func (c *activeConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+
+	if n > 0 {
+		c.session.last.Store(time.Now().UnixNano())
+		if c.upstream {
+			c.session.received.Add(uint64(n))
+		}
+	}
+
+	return n, err
+}
+
+// CloseWrite shuts down the writing side of the connection, so a half-close is passed through the session.
+func (c *activeConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+// Write writes data to the connection and updates the session activity.
+func (c *activeConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	if n > 0 {
+		c.session.last.Store(time.Now().UnixNano())
+		if c.upstream {
+			c.session.sent.Add(uint64(n))
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,160 @@
+package conn
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipe returns tracked client side and the server side of the connection.
+func pipe(t *testing.T, s *Session) (net.Conn, net.Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return s.Track(client), server
+}
+
+// waitClosed waits the peer of the connection is closed.
+func waitClosed(t *testing.T, c net.Conn) {
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		if errors.Is(err, io.ErrClosedPipe) {
+			return // already closed
+		}
+		t.Fatal(err)
+	}
+
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("expected closed connection, got %v", err)
+	}
+}
+
+func TestSession_Idle(t *testing.T) {
+	const idle = 100 * time.Millisecond
+	var (
+		s            = NewSession(Timeouts{Idle: idle})
+		client, peer = pipe(t, s)
+		started      = time.Now()
+	)
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _, _ = io.Copy(io.Discard, peer) }()
+
+	// activity keeps the session
+	for range 3 {
+		time.Sleep(idle / 2)
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expected closed connection, got %v", err)
+	}
+
+	if d := time.Since(started); d < 3*idle/2+idle {
+		t.Errorf("session is closed too early: %v", d)
+	}
+
+	if reason := s.Reason(); reason != ReasonIdle {
+		t.Errorf("unexpected reason %q", reason)
+	}
+}
+
+func TestSession_Lifetime(t *testing.T) {
+	var (
+		s         = NewSession(Timeouts{Idle: time.Minute, Lifetime: 50 * time.Millisecond})
+		_, peer   = pipe(t, s)
+		_, second = pipe(t, s)
+	)
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitClosed(t, peer)
+	waitClosed(t, second)
+
+	if reason := s.Reason(); reason != ReasonLifetime {
+		t.Errorf("unexpected reason %q", reason)
+	}
+}
+
+func TestSession_Handshake(t *testing.T) {
+	var (
+		s       = NewSession(Timeouts{})
+		c       = &testConn{}
+		tracked = s.Track(c)
+	)
+
+	if err := s.SetTimeouts(Timeouts{Handshake: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := c.readDeadline.Sub(s.started); d != time.Second {
+		t.Errorf("unexpected handshake deadline %v", d)
+	}
+
+	if s.Timeouts().Handshake != time.Second {
+		t.Errorf("unexpected timeouts %v", s.Timeouts())
+	}
+
+	if err := s.Close("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the first reason is saved, finished session is not started
+	if err := errors.Join(s.Close("second"), s.Start()); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason := s.Reason(); reason != "test" || s.timer != nil {
+		t.Errorf("unexpected reason %q or started timer", reason)
+	}
+
+	if _, err := tracked.Write(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSession_Stats(t *testing.T) {
+	var (
+		s              = NewSession(Timeouts{})
+		upstream, peer = net.Pipe()
+	)
+	defer func() { _ = peer.Close() }()
+
+	tracked := s.TrackUpstream(upstream)
+	defer func() { _ = tracked.Close() }()
+
+	go func() {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(peer, buf); err == nil {
+			_, _ = peer.Write([]byte("pong!"))
+		}
+	}()
+
+	if _, err := tracked.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.ReadFull(tracked, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := s.Stats()
+	if stats.Sent != 4 || stats.Received != 5 {
+		t.Errorf("unexpected bytes: sent=%d, received=%d", stats.Sent, stats.Received)
+	}
+
+	if !stats.Last.After(stats.Started) {
+		t.Errorf("unexpected last activity %v, started %v", stats.Last, stats.Started)
+	}
+}
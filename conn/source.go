@@ -0,0 +1,181 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+// Strategy is a rotation strategy of outbound source addresses.
+type Strategy string
+
+// Source address rotation strategies.
+const (
+	StrategyRoundRobin Strategy = "round-robin"
+	StrategyRandom     Strategy = "random"
+)
+
+var (
+	// ErrSource is returned when the source address or users file is invalid.
+	ErrSource = errors.New("invalid source address")
+	// ErrStrategy is returned when the rotation strategy is unknown.
+	ErrStrategy = errors.New("unknown source rotation strategy")
+)
+
+// ParseStrategy checks that the value is a known rotation strategy.
+func ParseStrategy(value string) (Strategy, error) {
+	switch s := Strategy(value); s {
+	case StrategyRoundRobin, StrategyRandom:
+		return s, nil
+	default:
+		return "", errors.Join(ErrStrategy, fmt.Errorf("invalid strategy %q", value))
+	}
+}
+
+// addressPool is a set of source addresses of one address family.
+type addressPool struct {
+	ips     []net.IP
+	counter atomic.Uint64
+}
+
+// next returns a source address from the pool by the strategy or nil for the empty pool.
+func (ap *addressPool) next(strategy Strategy) net.IP {
+	n := uint64(len(ap.ips))
+
+	switch {
+	case n == 0:
+		return nil
+	case n == 1:
+		return ap.ips[0]
+	case strategy == StrategyRandom:
+		return ap.ips[rand.Uint64N(n)] // #nosec G404, no need crypto random
+	default:
+		return ap.ips[(ap.counter.Add(1)-1)%n]
+	}
+}
+
+// sources are separated IPv4 and IPv6 source address pools.
+type sources struct {
+	v4 addressPool
+	v6 addressPool
+}
+
+// next returns a source address of the family by the strategy or nil if there is no such address.
+func (s *sources) next(ipv6 bool, strategy Strategy) net.IP {
+	if ipv6 {
+		return s.v6.next(strategy)
+	}
+
+	return s.v4.next(strategy)
+}
+
+// newSources returns sources from the IP addresses.
+func newSources(ips []net.IP) *sources {
+	s := &sources{}
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			s.v4.ips = append(s.v4.ips, ip)
+		} else {
+			s.v6.ips = append(s.v6.ips, ip)
+		}
+	}
+
+	return s
+}
+
+// SourcePool selects outbound source addresses with the same family as the destination.
+// Users can have own pools, other users and anonymous clients get addresses from the default one.
+type SourcePool struct {
+	strategy Strategy
+	common   *sources
+	users    map[string]*sources
+}
+
+// NewSourcePool returns a new SourcePool with the default addresses
+// and users' addresses from the file with lines "user IP [IP...]".
+// Both parameters can be empty.
+func NewSourcePool(addresses []net.IP, usersFile string, strategy Strategy) (*SourcePool, error) {
+	sp := &SourcePool{strategy: strategy, common: newSources(addresses)}
+
+	if usersFile == "" {
+		return sp, nil
+	}
+
+	users := make(map[string][]net.IP)
+	if err := lines.Read(usersFile, parseUserSources(users)); err != nil {
+		return nil, errors.Join(ErrSource, err)
+	}
+
+	sp.users = make(map[string]*sources, len(users))
+	for user, ips := range users {
+		sp.users[user] = newSources(ips)
+	}
+
+	return sp, nil
+}
+
+// ParseAddresses returns IP addresses from the comma-separated value.
+func ParseAddresses(value string) ([]net.IP, error) {
+	var ips []net.IP
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		ip := net.ParseIP(item)
+		if ip == nil {
+			return nil, errors.Join(ErrSource, fmt.Errorf("invalid IP address %q", item))
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+// parseUserSources returns a parser of lines "user IP [IP...]" which adds addresses to the users.
+func parseUserSources(users map[string][]net.IP) func([]string) error {
+	return func(values []string) error {
+		if len(values) < 2 {
+			return fmt.Errorf("expected user and IP addresses, got %q", strings.Join(values, " "))
+		}
+
+		ips, err := ParseAddresses(strings.Join(values[1:], ","))
+		if err != nil {
+			return err
+		}
+
+		users[values[0]] = append(users[values[0]], ips...)
+		return nil
+	}
+}
+
+// Select returns a source address for the user and destination address "host:port".
+// The default pool is used if the user's one has no address with the destination family.
+// It returns nil if there is no address with the destination family in both of them.
+func (sp *SourcePool) Select(user, addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ipv6 := false
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		ipv6 = true
+	}
+
+	if s, ok := sp.users[user]; ok {
+		if ip := s.next(ipv6, sp.strategy); ip != nil {
+			return ip
+		}
+	}
+
+	return sp.common.next(ipv6, sp.strategy)
+}
@@ -0,0 +1,151 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+func TestParseStrategy(t *testing.T) {
+	for _, value := range []string{"round-robin", "random"} {
+		if s, err := ParseStrategy(value); err != nil || string(s) != value {
+			t.Errorf("ParseStrategy(%q) = %q, %v", value, s, err)
+		}
+	}
+
+	if _, err := ParseStrategy("first"); !errors.Is(err, ErrStrategy) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	ips, err := ParseAddresses("10.0.0.1, 10.0.0.2,,2001:db8::1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := len(ips); n != 3 {
+		t.Errorf("expected 3 addresses, got %d", n)
+	}
+
+	if _, err = ParseAddresses("10.0.0.1,bad"); !errors.Is(err, ErrSource) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSourcePool_Select(t *testing.T) {
+	ips, err := ParseAddresses("10.0.0.1,10.0.0.2,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.CreateTemp("", "sources_gsocks5_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := os.Remove(f.Name()); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	if _, err = f.WriteString("# users\nalice 10.0.1.1 # comment\n\nbob 2001:db8::2\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sp, err := NewSourcePool(ips, f.Name(), StrategyRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		user string
+		addr string
+		want []string
+	}{
+		{name: "roundRobin", addr: "1.1.1.1:443", want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"}},
+		{name: "ipv6", addr: "[2606:4700::1111]:443", want: []string{"2001:db8::1", "2001:db8::1"}},
+		{name: "user", user: "alice", addr: "1.1.1.1:443", want: []string{"10.0.1.1", "10.0.1.1"}},
+		{name: "userNoFamily", user: "bob", addr: "1.1.1.1:443", want: []string{"10.0.0.2"}},
+		{name: "userIPv6", user: "bob", addr: "[2606:4700::1111]:443", want: []string{"2001:db8::2"}},
+		{name: "unknownUser", user: "eve", addr: "1.1.1.1:443", want: []string{"10.0.0.1"}},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			for _, want := range tc.want {
+				if ip := sp.Select(tc.user, tc.addr); ip.String() != want {
+					t.Errorf("Select(%q, %q) = %v, want %s", tc.user, tc.addr, ip, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSourcePool(t *testing.T) {
+	if _, err := NewSourcePool(nil, "/not/existing/file", StrategyRandom); !errors.Is(err, ErrSource) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := lines.Scan(strings.NewReader("alice\n"), parseUserSources(make(map[string][]net.IP))); err == nil {
+		t.Error("expected error for user without addresses")
+	}
+
+	sp, err := NewSourcePool([]net.IP{net.ParseIP("10.0.0.1")}, "", StrategyRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip := sp.Select("", "1.1.1.1:80"); ip.String() != "10.0.0.1" {
+		t.Errorf("unexpected source address %v", ip)
+	}
+
+	// no address of the destination family, the system chooses it
+	if ip := sp.Select("", "[2606:4700::1111]:80"); ip != nil {
+		t.Errorf("unexpected source address %v", ip)
+	}
+}
+
+func TestDial_Sources(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := listener.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	sp, err := NewSourcePool([]net.IP{net.ParseIP("127.0.0.2")}, "", StrategyRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connFunc := Dial(&net.Dialer{Timeout: timeout}, timeout, logger, WithSources(sp))
+	ctx := WithRequest(context.Background(), &Request{User: "alice"})
+
+	connection, err := connFunc(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ip := connection.LocalAddr().(*net.TCPAddr).IP.String(); ip != "127.0.0.2" {
+		t.Errorf("unexpected source address %s", ip)
+	}
+
+	if err = connection.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,99 @@
+package conn
+
+import (
+	"net"
+	"time"
+
+	"github.com/z0rr0/gsocks5/limit"
+)
+
+// throttledConn is a net.Conn wrapper with bandwidth limitation by token buckets.
+// Data is read and written by chunks not bigger than the smallest bucket burst,
+// so one session can not take all tokens of shared buckets at once.
+type throttledConn struct {
+	net.Conn
+	down      []*limit.Bucket
+	up        []*limit.Bucket
+	downChunk int
+	upChunk   int
+}
+
+// newThrottledConn returns the connection with bandwidth limits.
+// It returns the same connection if there are no buckets to keep direct data copying.
+func newThrottledConn(conn net.Conn, down, up []*limit.Bucket) net.Conn {
+	if len(down) == 0 && len(up) == 0 {
+		return conn
+	}
+
+	return &throttledConn{Conn: conn, down: down, up: up, downChunk: chunkSize(down), upChunk: chunkSize(up)}
+}
+
+// chunkSize returns the smallest burst of the buckets or zero without buckets.
+func chunkSize(buckets []*limit.Bucket) int {
+	size := 0
+
+	for _, b := range buckets {
+		if burst := b.Burst(); size == 0 || burst < size {
+			size = burst
+		}
+	}
+
+	return max(size, 0)
+}
+
+// wait reserves n tokens in all buckets and sleeps the longest delay.
+func wait(buckets []*limit.Bucket, n int) {
+	var delay time.Duration
+
+	for _, b := range buckets {
+		delay = max(delay, b.Reserve(n))
+	}
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Read reads data from the connection, it's a download direction.
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if c.downChunk > 0 && len(b) > c.downChunk {
+		b = b[:c.downChunk]
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		wait(c.down, n)
+	}
+
+	return n, err
+}
+
+// CloseWrite shuts down the writing side of the connection.
+func (c *throttledConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+// Write writes data to the connection, it's an upload direction.
+func (c *throttledConn) Write(b []byte) (int, error) {
+	var written int
+
+	for len(b) > 0 {
+		chunk := b
+		if c.upChunk > 0 && len(chunk) > c.upChunk {
+			chunk = chunk[:c.upChunk]
+		}
+
+		wait(c.up, len(chunk))
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+
+		if err != nil {
+			return written, err
+		}
+
+		b = b[n:]
+	}
+
+	return written, nil
+}
@@ -0,0 +1,72 @@
+package conn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/gsocks5/limit"
+)
+
+// countConn is a test net.Conn implementation that counts read and written bytes by operations.
+type countConn struct {
+	testConn
+	reads  []int
+	writes []int
+}
+
+func (c *countConn) Read(b []byte) (int, error) {
+	c.reads = append(c.reads, len(b))
+	return len(b), nil
+}
+func (c *countConn) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, len(b))
+	return len(b), nil
+}
+
+func TestNewThrottledConn(t *testing.T) {
+	var connection net.Conn = &testConn{}
+
+	if c := newThrottledConn(connection, nil, nil); c != connection {
+		t.Errorf("expected the same connection, got %T", c)
+	}
+
+	c := newThrottledConn(connection, []*limit.Bucket{limit.NewBucket(100, 10), limit.NewBucket(100, 5)}, nil)
+	tc, ok := c.(*throttledConn)
+	if !ok {
+		t.Fatalf("unexpected connection type %T", c)
+	}
+
+	if tc.downChunk != 5 || tc.upChunk != 0 {
+		t.Errorf("unexpected chunks %d/%d", tc.downChunk, tc.upChunk)
+	}
+}
+
+func TestThrottledConn(t *testing.T) {
+	const rate = 1000
+	var (
+		connection = &countConn{}
+		down       = []*limit.Bucket{limit.NewBucket(rate, 100)}
+		up         = []*limit.Bucket{limit.NewBucket(rate, 100)}
+		c          = newThrottledConn(connection, down, up)
+		start      = time.Now()
+	)
+
+	n, err := c.Write(make([]byte, 300))
+	if err != nil || n != 300 {
+		t.Fatalf("unexpected write result: %d, %v", n, err)
+	}
+
+	// burst is taken immediately, other 200 bytes need 200ms
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("write is too fast: %v", d)
+	}
+
+	if len(connection.writes) != 3 {
+		t.Errorf("expected 3 write chunks, got %v", connection.writes)
+	}
+
+	if n, err = c.Read(make([]byte, 300)); err != nil || n != 100 {
+		t.Errorf("unexpected read result: %d, %v", n, err)
+	}
+}
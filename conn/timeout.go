@@ -0,0 +1,105 @@
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+// ErrTimeout is returned when the users' timeouts file is invalid.
+var ErrTimeout = errors.New("invalid timeouts file")
+
+// Timeouts are session timers, zero value means no limit.
+type Timeouts struct {
+	Handshake time.Duration // client greeting, authentication and request
+	Idle      time.Duration // no data in both directions on both client and upstream connections
+	Lifetime  time.Duration // max session duration since the client connection
+}
+
+// String returns timeouts as "handshake=X idle=Y lifetime=Z".
+func (t Timeouts) String() string {
+	return fmt.Sprintf("handshake=%v idle=%v lifetime=%v", t.Handshake, t.Idle, t.Lifetime)
+}
+
+// override returns the timeouts where non-zero values are replaced by ones from u.
+func (t Timeouts) override(u Timeouts) Timeouts {
+	if u.Handshake > 0 {
+		t.Handshake = u.Handshake
+	}
+
+	if u.Idle > 0 {
+		t.Idle = u.Idle
+	}
+
+	if u.Lifetime > 0 {
+		t.Lifetime = u.Lifetime
+	}
+
+	return t
+}
+
+// TimeoutPolicy is a set of global and users' session timeouts.
+type TimeoutPolicy struct {
+	global Timeouts
+	users  map[string]Timeouts
+}
+
+// NewTimeoutPolicy returns a new TimeoutPolicy with global timeouts
+// and users' ones from the file with lines "user handshake|idle|lifetime DURATION".
+// The file name can be empty, users' values override only their timers.
+func NewTimeoutPolicy(global Timeouts, usersFile string) (*TimeoutPolicy, error) {
+	tp := &TimeoutPolicy{global: global}
+
+	if usersFile == "" {
+		return tp, nil
+	}
+
+	tp.users = make(map[string]Timeouts)
+	if err := lines.Read(usersFile, parseUserTimeouts(tp.users)); err != nil {
+		return nil, errors.Join(ErrTimeout, err)
+	}
+
+	return tp, nil
+}
+
+// parseUserTimeouts returns a parser of lines "user handshake|idle|lifetime DURATION" which sets users' timers.
+func parseUserTimeouts(users map[string]Timeouts) func([]string) error {
+	return func(values []string) error {
+		if len(values) != 3 {
+			return fmt.Errorf("expected user, timer and duration, got %q", strings.Join(values, " "))
+		}
+
+		d, err := time.ParseDuration(values[2])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", values[2])
+		}
+
+		t := users[values[0]]
+		switch values[1] {
+		case "handshake":
+			t.Handshake = d
+		case "idle":
+			t.Idle = d
+		case "lifetime":
+			t.Lifetime = d
+		default:
+			return fmt.Errorf("unknown timer %q", values[1])
+		}
+
+		users[values[0]] = t
+		return nil
+	}
+}
+
+// User returns timeouts of the user, empty name returns global ones.
+// Nil policy has no timeouts.
+func (tp *TimeoutPolicy) User(user string) Timeouts {
+	if tp == nil {
+		return Timeouts{}
+	}
+
+	return tp.global.override(tp.users[user])
+}
@@ -0,0 +1,80 @@
+package conn
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+func TestParseUserTimeouts(t *testing.T) {
+	users := make(map[string]Timeouts)
+	err := lines.Scan(strings.NewReader(
+		"# user timer duration\nalice idle 30s\nalice lifetime 1h\n\nbob handshake 5s # slow client\n",
+	), parseUserTimeouts(users))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Timeouts{
+		"alice": {Idle: 30 * time.Second, Lifetime: time.Hour},
+		"bob":   {Handshake: 5 * time.Second},
+	}
+	for user, timeouts := range expected {
+		if users[user] != timeouts {
+			t.Errorf("unexpected %s timeouts: %v", user, users[user])
+		}
+	}
+
+	for _, line := range []string{"alice idle", "alice idle 0s", "alice idle bad", "alice read 1s"} {
+		if err = lines.Scan(strings.NewReader(line), parseUserTimeouts(users)); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestTimeoutPolicy_User(t *testing.T) {
+	var tp *TimeoutPolicy
+	if timeouts := tp.User("alice"); timeouts != (Timeouts{}) {
+		t.Errorf("expected no timeouts for nil policy, got %v", timeouts)
+	}
+
+	if _, err := NewTimeoutPolicy(Timeouts{}, "/not/existing/file"); !errors.Is(err, ErrTimeout) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	f, err := os.CreateTemp("", "timeouts_gsocks5_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := os.Remove(f.Name()); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	if _, err = f.WriteString("alice idle 30s\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	global := Timeouts{Handshake: 10 * time.Second, Idle: time.Minute, Lifetime: time.Hour}
+	if tp, err = NewTimeoutPolicy(global, f.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	if timeouts := tp.User("bob"); timeouts != global {
+		t.Errorf("expected global timeouts, got %v", timeouts)
+	}
+
+	expected := Timeouts{Handshake: 10 * time.Second, Idle: 30 * time.Second, Lifetime: time.Hour}
+	if timeouts := tp.User("alice"); timeouts != expected {
+		t.Errorf("expected %v, got %v", expected, timeouts)
+	}
+}
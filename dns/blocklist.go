@@ -0,0 +1,310 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/internal/domain"
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+// ErrBlocklist is returned when a blocklist file can not be loaded.
+var ErrBlocklist = errors.New("invalid blocklist file")
+
+// blockedKey is a context key for the name of a blocklist that matched the requested domain.
+type blockedKey struct{}
+
+// suffixTrie is a trie of domain labels in reversed order,
+// a terminal node blocks the domain and all its subdomains.
+type suffixTrie struct {
+	children map[string]*suffixTrie
+	terminal bool
+}
+
+// add inserts the domain to the trie.
+func (t *suffixTrie) add(domain string) {
+	node := t
+	for labels := domain; labels != ""; {
+		var label string
+		label, labels = lastLabel(labels)
+
+		if node.terminal {
+			return // the parent domain is already blocked
+		}
+
+		child, ok := node.children[label]
+		if !ok {
+			child = &suffixTrie{}
+			if node.children == nil {
+				node.children = make(map[string]*suffixTrie)
+			}
+			node.children[label] = child
+		}
+		node = child
+	}
+
+	node.terminal = true
+	node.children = nil // subdomains are covered by the terminal node
+}
+
+// match returns true if the domain or any of its parent domains is in the trie.
+func (t *suffixTrie) match(domain string) bool {
+	node := t
+	for labels := domain; labels != ""; {
+		var label string
+		label, labels = lastLabel(labels)
+
+		if node = node.children[label]; node == nil {
+			return false
+		}
+
+		if node.terminal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lastLabel splits the domain to its last label and the rest part.
+func lastLabel(domain string) (string, string) {
+	i := strings.LastIndexByte(domain, '.')
+	if i < 0 {
+		return domain, ""
+	}
+	return domain[i+1:], domain[:i]
+}
+
+// blocklist is a set of blocked domains loaded from one file.
+type blocklist struct {
+	name     string
+	fileName string
+	modTime  time.Time
+	trie     atomic.Pointer[suffixTrie]
+	entries  atomic.Int64  // number of blocked domains
+	skipped  atomic.Int64  // number of lines that can not be parsed
+	blocked  atomic.Uint64 // number of blocked lookups
+}
+
+// load reads the blocklist file if it was modified since the last load.
+func (bl *blocklist) load() (bool, error) {
+	stat, err := os.Stat(bl.fileName)
+	if err != nil {
+		return false, errors.Join(ErrBlocklist, err)
+	}
+
+	if stat.ModTime().Equal(bl.modTime) && bl.trie.Load() != nil {
+		return false, nil
+	}
+
+	// public lists have lines of other formats, they are skipped instead of failing the whole list
+	var skipped int64
+	records, err := lines.ReadAll(bl.fileName, func(fields []string) ([]string, error) {
+		domains, ok := parseBlocked(fields)
+		if !ok {
+			skipped++
+		}
+		return domains, nil
+	})
+	if err != nil {
+		return false, errors.Join(ErrBlocklist, err)
+	}
+
+	var (
+		trie    = &suffixTrie{}
+		entries int64
+	)
+	for _, domains := range records {
+		for _, domain := range domains {
+			trie.add(domain)
+			entries++
+		}
+	}
+
+	bl.trie.Store(trie)
+	bl.modTime = stat.ModTime()
+	bl.entries.Store(entries)
+	bl.skipped.Store(skipped)
+	return true, nil
+}
+
+// parseBlocked parses a hosts file line "IP domain [domain...]" or a plain domain list line "domain".
+// Entries that can not be blocked, like "localhost" or IP addresses, are not returned.
+// It returns false if the line has an unknown format.
+func parseBlocked(fields []string) ([]string, bool) {
+	names := fields
+	if len(fields) > 1 {
+		// the address can have a zone, like "fe80::1%lo0"
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			return nil, false
+		}
+		names = fields[1:]
+	}
+
+	domains := make([]string, 0, len(names))
+	for _, name := range names {
+		host := strings.TrimPrefix(domain.Normalize(name), "*.")
+		if strings.Contains(host, ".") && net.ParseIP(host) == nil {
+			domains = append(domains, host)
+		}
+	}
+
+	return domains, true
+}
+
+// Blocker is a name resolver that refuses to resolve domains from blocklists.
+type Blocker struct {
+	sync.Mutex // protects lists reloading
+	lists      []*blocklist
+	next       socks5.NameResolver
+	logger     *slog.Logger
+}
+
+// NewBlocker returns a new Blocker with blocklists from the files and the next resolver for allowed names.
+// Files can be in hosts or plain domain list format.
+func NewBlocker(files []string, next socks5.NameResolver, logger *slog.Logger) (*Blocker, error) {
+	b := &Blocker{next: next, logger: logger}
+
+	for _, fileName := range files {
+		fileName = filepath.Clean(fileName)
+		b.lists = append(b.lists, &blocklist{name: filepath.Base(fileName), fileName: fileName})
+	}
+
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Reload reads modified blocklist files again.
+// A list keeps its previous content if the file can not be loaded.
+func (b *Blocker) Reload() error {
+	var errs []error
+
+	b.Lock()
+	defer b.Unlock()
+
+	for _, bl := range b.lists {
+		updated, err := bl.load()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("blocklist %q: %w", bl.name, err))
+			continue
+		}
+
+		if updated {
+			b.logger.Info(
+				"blocklist loaded",
+				"blocklist", bl.name, "domains", bl.entries.Load(), "skipped", bl.skipped.Load(),
+			)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Refresh reloads blocklists every interval until the context is done.
+func (b *Blocker) Refresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.Reload(); err != nil {
+				b.logger.Error("failed to refresh blocklists", "error", err)
+			}
+		}
+	}
+}
+
+// Match returns the name of the first blocklist that contains the domain.
+func (b *Blocker) Match(name string) (string, bool) {
+	if bl := b.match(name); bl != nil {
+		return bl.name, true
+	}
+	return "", false
+}
+
+// match returns the first blocklist that contains the domain or nil.
+func (b *Blocker) match(name string) *blocklist {
+	fqdn := domain.Normalize(name)
+
+	for _, bl := range b.lists {
+		if trie := bl.trie.Load(); trie != nil && trie.match(fqdn) {
+			return bl
+		}
+	}
+
+	return nil
+}
+
+// Resolve resolves the given host name if it is not blocked.
+// A blocked name is not resolved and marked in the returned context to be rejected by rules.
+func (b *Blocker) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	if bl := b.match(name); bl != nil {
+		bl.blocked.Add(1)
+		b.logger.Debug("name is blocked", "dest", name, "blocklist", bl.name)
+		return context.WithValue(ctx, blockedKey{}, bl.name), nil, nil
+	}
+
+	return b.next.Resolve(ctx, name)
+}
+
+// ListStats are statistics of one blocklist.
+type ListStats struct {
+	Entries int64  // number of blocked domains
+	Skipped int64  // number of lines that can not be parsed
+	Blocked uint64 // number of blocked lookups
+}
+
+// Stats returns statistics per blocklist name, lists with the same name are summed.
+func (b *Blocker) Stats() map[string]ListStats {
+	stats := make(map[string]ListStats, len(b.lists))
+
+	for _, bl := range b.lists {
+		s := stats[bl.name]
+		s.Entries += bl.entries.Load()
+		s.Skipped += bl.skipped.Load()
+		s.Blocked += bl.blocked.Load()
+		stats[bl.name] = s
+	}
+
+	return stats
+}
+
+// Rules returns a rule set that rejects requests with blocked names and checks others by the next rule set.
+func (b *Blocker) Rules(next socks5.RuleSet) socks5.RuleSet {
+	if next == nil {
+		next = socks5.PermitAll()
+	}
+	return &blockRules{next: next}
+}
+
+// blockRules is a rule set that rejects requests to blocked domains.
+type blockRules struct {
+	next socks5.RuleSet
+}
+
+// Allow rejects the request if its name was blocked during resolving.
+func (br *blockRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if _, ok := ctx.Value(blockedKey{}).(string); ok {
+		return ctx, false
+	}
+
+	return br.next.Allow(ctx, req)
+}
@@ -0,0 +1,159 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/armon/go-socks5"
+)
+
+func TestSuffixTrie(t *testing.T) {
+	trie := &suffixTrie{}
+	for _, domain := range []string{"ads.example.com", "tracker.net", "a.b.tracker.net"} {
+		trie.add(domain)
+	}
+
+	testCases := []struct {
+		domain string
+		want   bool
+	}{
+		{domain: "ads.example.com", want: true},
+		{domain: "x.ads.example.com", want: true},
+		{domain: "example.com"},
+		{domain: "bads.example.com"},
+		{domain: "tracker.net", want: true},
+		{domain: "c.b.tracker.net", want: true},
+		{domain: "net"},
+		{domain: "github.com"},
+	}
+
+	for _, tc := range testCases {
+		if got := trie.match(tc.domain); got != tc.want {
+			t.Errorf("match(%q) = %v, want %v", tc.domain, got, tc.want)
+		}
+	}
+}
+
+func TestParseBlocked(t *testing.T) {
+	testCases := []struct {
+		line    []string
+		want    string
+		skipped bool
+	}{
+		{line: []string{"ads.example.com"}, want: "ads.example.com"},
+		{line: []string{"0.0.0.0", "Ads.Example.Com."}, want: "ads.example.com"},
+		{line: []string{"*.tracker.net"}, want: "tracker.net"},
+		{line: []string{"127.0.0.1", "localhost"}},
+		{line: []string{"::1", "localhost", "ip6-localhost"}},
+		{line: []string{"fe80::1%lo0", "localhost"}},
+		{line: []string{"0.0.0.0", "0.0.0.0"}},
+		{line: []string{"0.0.0.0", "a.com", "b.com"}, want: "a.com,b.com"},
+		{line: []string{"bad", "ads.example.com"}, skipped: true},
+	}
+
+	for _, tc := range testCases {
+		got, ok := parseBlocked(tc.line)
+		if ok == tc.skipped {
+			t.Errorf("parseBlocked(%v) ok = %v, want skipped %v", tc.line, ok, tc.skipped)
+			continue
+		}
+
+		if s := strings.Join(got, ","); s != tc.want {
+			t.Errorf("parseBlocked(%v) = %q, want %q", tc.line, s, tc.want)
+		}
+	}
+}
+
+func TestBlocker(t *testing.T) {
+	var (
+		hostsList  = tempFile(t, "# hosts format\n0.0.0.0 ads.example.com\n127.0.0.1 localhost\nbad line\n")
+		domainList = tempFile(t, "tracker.net\n")
+		allowed    = net.ParseIP("10.0.0.1")
+	)
+
+	b, err := NewBlocker([]string{hostsList, domainList}, staticResolver(allowed), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := b.Rules(nil)
+	testCases := []struct {
+		name    string
+		host    string
+		blocked bool
+	}{
+		{name: "hosts", host: "ads.example.com", blocked: true},
+		{name: "subdomain", host: "cdn.tracker.net", blocked: true},
+		{name: "allowed", host: "github.com"},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, ip, e := b.Resolve(context.Background(), tc.host)
+			if e != nil {
+				t.Fatalf("unexpected error: %v", e)
+			}
+
+			if tc.blocked == (ip != nil) {
+				t.Errorf("unexpected IP %v for blocked=%v", ip, tc.blocked)
+			}
+
+			req := &socks5.Request{Command: socks5.ConnectCommand, DestAddr: &socks5.AddrSpec{FQDN: tc.host, IP: ip, Port: 443}}
+			if _, ok := rules.Allow(ctx, req); ok == tc.blocked {
+				t.Errorf("Allow() = %v, blocked %v", ok, tc.blocked)
+			}
+		})
+	}
+
+	stats := b.Stats()
+	for _, fileName := range []string{hostsList, domainList} {
+		if s := stats[filepath.Base(fileName)]; s.Blocked != 1 || s.Entries != 1 {
+			t.Errorf("expected 1 entry and 1 blocked lookup for %s, got %+v", fileName, s)
+		}
+	}
+
+	if s := stats[filepath.Base(hostsList)]; s.Skipped != 1 {
+		t.Errorf("expected 1 skipped line, got %+v", s)
+	}
+
+	if err = os.WriteFile(domainList, []byte("github.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the modification time can be the same, so reset it to force loading
+	b.lists[1].modTime = b.lists[1].modTime.AddDate(-1, 0, 0)
+	if err = b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, ok := b.Match("github.com"); !ok || list != filepath.Base(domainList) {
+		t.Errorf("expected github.com to be blocked by %s, got %q", domainList, list)
+	}
+
+	if _, ok := b.Match("tracker.net"); ok {
+		t.Error("expected tracker.net to be allowed after reload")
+	}
+}
+
+func TestNewBlocker(t *testing.T) {
+	if _, err := NewBlocker([]string{"/not/existing/file"}, staticResolver{}, logger); err == nil {
+		t.Error("expected error for not existing file")
+	}
+
+	// a bad line does not fail the whole list
+	badList := tempFile(t, "bad ads.example.com\ntracker.net\n")
+	b, err := NewBlocker([]string{badList}, staticResolver{}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := b.Stats()[filepath.Base(badList)]; s.Entries != 1 || s.Skipped != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
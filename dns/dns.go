@@ -4,15 +4,33 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/armon/go-socks5"
 )
 
-// ErrHostIP is returned when the DNS host is invalid.
-var ErrHostIP = errors.New("DNS host is not an IP address")
+// defaultPort is a standard DNS server port.
+const defaultPort = "53"
+
+// Transport is a network transport to connect to DNS servers.
+type Transport string
+
+// DNS transports.
+const (
+	TransportUDP         Transport = "udp"
+	TransportTCP         Transport = "tcp"
+	TransportUDPFallback Transport = "udp-tcp" // UDP with TCP fallback for truncated responses
+)
+
+var (
+	// ErrAddress is returned when the DNS server address is invalid.
+	ErrAddress = errors.New("DNS server address must be IP or IP:port")
+	// ErrTransport is returned when the DNS transport is unknown.
+	ErrTransport = errors.New("unknown DNS transport")
+)
 
 // nameResolver is a nameResolver that uses a custom DNS server.
 type nameResolver struct {
@@ -34,32 +52,124 @@ func (nr *nameResolver) Resolve(ctx context.Context, name string) (context.Conte
 	return ctx, ips[0], nil
 }
 
+// ParseTransport checks that the value is a known DNS transport.
+func ParseTransport(value string) (Transport, error) {
+	switch t := Transport(value); t {
+	case TransportUDP, TransportTCP, TransportUDPFallback:
+		return t, nil
+	default:
+		return "", errors.Join(ErrTransport, fmt.Errorf("invalid DNS transport %q", value))
+	}
+}
+
+// ParseAddress returns DNS server address "IP:port" from the value.
+// The value can be an IP address, "IPv4:port" or "[IPv6]:port", the port is 53 by default.
+func ParseAddress(value string) (string, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		return net.JoinHostPort(ip.String(), defaultPort), nil
+	}
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return "", errors.Join(ErrAddress, fmt.Errorf("invalid DNS server %q: %w", value, err))
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", errors.Join(ErrAddress, fmt.Errorf("invalid DNS server %q: host is not an IP address", value))
+	}
+
+	if p, e := strconv.ParseUint(port, 10, 16); e != nil || p == 0 {
+		return "", errors.Join(ErrAddress, fmt.Errorf("invalid DNS server %q: bad port %q", value, port))
+	}
+
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
 // New returns a new name nameResolver.
-func New(dnsHost string, timeout time.Duration, loggerInfo, loggerDebug *log.Logger) (socks5.NameResolver, error) {
-	const port = "53"
+// If the DNS server is not set, the system one is used with the transport.
+func New(
+	dnsHost string,
+	transport Transport,
+	timeout time.Duration,
+	logger *slog.Logger,
+) (socks5.NameResolver, error) {
+	transport, err := ParseTransport(string(transport))
+	if err != nil {
+		return nil, err
+	}
 
 	if dnsHost == "" {
-		loggerInfo.Printf("use default DNS name resolver")
-		return socks5.DNSResolver{}, nil
+		if transport == TransportUDPFallback {
+			logger.Info("use default DNS name resolver")
+			return socks5.DNSResolver{}, nil
+		}
+
+		logger.Info("using system DNS servers", "transport", transport)
+		return newNameResolver("", transport, timeout, logger), nil
 	}
 
-	ip := net.ParseIP(dnsHost)
-	if ip == nil {
-		return nil, errors.Join(ErrHostIP, fmt.Errorf("invalid DNS host: %s", dnsHost))
+	address, err := ParseAddress(dnsHost)
+	if err != nil {
+		return nil, err
 	}
 
-	address := net.JoinHostPort(ip.String(), port)
-	loggerInfo.Printf("using DNS server %q", address)
+	logger.Info("using DNS server", "server", address, "transport", transport)
+	return newNameResolver(address, transport, timeout, logger), nil
+}
 
+// newNameResolver returns a new nameResolver that uses the DNS server with the given address,
+// empty address means servers of the system configuration.
+// Only TransportUDPFallback allows the Go resolver to choose the network by itself.
+func newNameResolver(
+	address string,
+	transport Transport,
+	timeout time.Duration,
+	logger *slog.Logger,
+) *nameResolver {
 	resolver := &net.Resolver{
 		PreferGo: true,
-		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+		Dial: func(ctx context.Context, network, server string) (net.Conn, error) {
 			var d = net.Dialer{Timeout: timeout}
-			loggerDebug.Printf("dialing DNS server %s, network %s, timeout %v", address, network, timeout)
 
-			return d.DialContext(ctx, network, address)
+			if transport != TransportUDPFallback {
+				network = string(transport)
+			}
+
+			if address != "" {
+				server = address
+			}
+
+			logger.Debug("dialing DNS server", "server", server, "network", network, "timeout", timeout)
+			return d.DialContext(ctx, network, server)
 		},
 	}
 
-	return &nameResolver{r: resolver}, nil
+	return &nameResolver{r: resolver}
+}
+
+// LookupObserver observes name lookups, it is used for metrics.
+type LookupObserver interface {
+	// ObserveLookup is called after every lookup with its duration and error.
+	ObserveLookup(d time.Duration, err error)
+}
+
+// observedResolver is a name resolver wrapper that passes lookups results to the observer.
+type observedResolver struct {
+	next     socks5.NameResolver
+	observer LookupObserver
+}
+
+// Observed returns the resolver which lookups are observed.
+func Observed(next socks5.NameResolver, observer LookupObserver) socks5.NameResolver {
+	return &observedResolver{next: next, observer: observer}
+}
+
+// Resolve resolves the name by the next resolver and observes the lookup.
+func (or *observedResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	started := time.Now()
+	ctx, ip, err := or.next.Resolve(ctx, name)
+	or.observer.ObserveLookup(time.Since(started), err)
+
+	return ctx, ip, err
 }
@@ -2,7 +2,9 @@ package dns
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -11,20 +13,24 @@ import (
 )
 
 var (
-	logger  = log.New(os.Stdout, "[test] ", log.LstdFlags|log.Lshortfile)
+	logger  = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
 	timeout = 2 * time.Second
 )
 
 func TestNew(t *testing.T) {
 	testCases := []struct {
-		name    string
-		dnsHost string
-		host    string
-		err     bool
+		name      string
+		dnsHost   string
+		transport Transport
+		host      string
+		err       bool
 	}{
 		{name: "default"},
 		{name: "google", dnsHost: "8.8.8.8", host: "github.com"},
+		{name: "googlePort", dnsHost: "8.8.8.8:53", transport: TransportUDP, host: "github.com"},
+		{name: "googleTCP", dnsHost: "[2001:4860:4860::8888]:53", transport: TransportTCP, host: "github.com"},
 		{name: "badDNS", dnsHost: "bad", err: true},
+		{name: "badTransport", dnsHost: "8.8.8.8", transport: "quic", err: true},
 		{name: "badDefault", host: "bad.bad.github.bad", err: true},
 		{name: "badCustom", host: "bad.bad.github.bad", dnsHost: "8.8.8.8", err: true},
 	}
@@ -32,7 +38,12 @@ func TestNew(t *testing.T) {
 		tc := testCases[i]
 
 		t.Run(tc.name, func(t *testing.T) {
-			nr, err := New(tc.dnsHost, timeout, logger, logger)
+			transport := tc.transport
+			if transport == "" {
+				transport = TransportUDPFallback
+			}
+
+			nr, err := New(tc.dnsHost, transport, timeout, logger)
 			if err != nil {
 				if !tc.err {
 					t.Errorf("unexpected error: %v", err)
@@ -74,3 +85,186 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestParseAddress(t *testing.T) {
+	testCases := []struct {
+		value string
+		want  string
+	}{
+		{value: "8.8.8.8", want: "8.8.8.8:53"},
+		{value: "127.0.0.1:5353", want: "127.0.0.1:5353"},
+		{value: "2001:4860:4860::8888", want: "[2001:4860:4860::8888]:53"},
+		{value: "[::1]:5353", want: "[::1]:5353"},
+		{value: "bad"},
+		{value: "bad:53"},
+		{value: "127.0.0.1:"},
+		{value: "127.0.0.1:0"},
+		{value: "127.0.0.1:65536"},
+		{value: "[::1]"},
+		{value: "::1:53:xyz"},
+	}
+
+	for _, tc := range testCases {
+		address, err := ParseAddress(tc.value)
+		if tc.want == "" {
+			if !errors.Is(err, ErrAddress) {
+				t.Errorf("ParseAddress(%q) error = %v, want ErrAddress", tc.value, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseAddress(%q) unexpected error: %v", tc.value, err)
+			continue
+		}
+
+		if address != tc.want {
+			t.Errorf("ParseAddress(%q) = %q, want %q", tc.value, address, tc.want)
+		}
+	}
+}
+
+func TestParseTransport(t *testing.T) {
+	for _, value := range []string{"udp", "tcp", "udp-tcp"} {
+		if transport, err := ParseTransport(value); err != nil || string(transport) != value {
+			t.Errorf("ParseTransport(%q) = %q, %v", value, transport, err)
+		}
+	}
+
+	for _, value := range []string{"", "UDP", "tls"} {
+		if _, err := ParseTransport(value); !errors.Is(err, ErrTransport) {
+			t.Errorf("ParseTransport(%q) error = %v, want ErrTransport", value, err)
+		}
+	}
+}
+
+func TestNameResolver_Transport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t.Run("tcp", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if e := listener.Close(); e != nil {
+				t.Error(e)
+			}
+		}()
+
+		nr := newNameResolver(listener.Addr().String(), TransportTCP, timeout, logger)
+		go nr.Resolve(ctx, "github.com") //nolint:errcheck
+
+		c, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("expected TCP connection: %v", err)
+		}
+
+		if err = c.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if e := pc.Close(); e != nil {
+				t.Error(e)
+			}
+		}()
+
+		if err = pc.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			t.Fatal(err)
+		}
+
+		nr := newNameResolver(pc.LocalAddr().String(), TransportUDP, timeout, logger)
+		go nr.Resolve(ctx, "github.com") //nolint:errcheck
+
+		if _, _, err = pc.ReadFrom(make([]byte, 512)); err != nil {
+			t.Fatalf("expected UDP packet: %v", err)
+		}
+	})
+
+	t.Run("system", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if e := listener.Close(); e != nil {
+				t.Error(e)
+			}
+		}()
+
+		nr, err := New("", TransportTCP, timeout, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, ok := nr.(*nameResolver)
+		if !ok {
+			t.Fatalf("expected custom nameResolver, got %T", nr)
+		}
+
+		// Go resolver passes a server of the system configuration, the transport is applied to it
+		c, err := r.r.Dial(ctx, "udp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if network := c.LocalAddr().Network(); network != "tcp" {
+			t.Errorf("expected TCP connection, got %s", network)
+		}
+
+		if err = c.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// testResolver is a test name resolver with the fixed result.
+type testResolver struct {
+	ip  net.IP
+	err error
+}
+
+func (tr *testResolver) Resolve(ctx context.Context, _ string) (context.Context, net.IP, error) {
+	return ctx, tr.ip, tr.err
+}
+
+// testObserver is a test LookupObserver implementation.
+type testObserver struct {
+	lookups, failed int
+}
+
+func (o *testObserver) ObserveLookup(_ time.Duration, err error) {
+	o.lookups++
+	if err != nil {
+		o.failed++
+	}
+}
+
+func TestObserved(t *testing.T) {
+	var (
+		observer = &testObserver{}
+		failure  = errors.New("lookup failure")
+		ctx      = context.Background()
+	)
+
+	_, ip, err := Observed(&testResolver{ip: net.IPv4(192, 0, 2, 1)}, observer).Resolve(ctx, "example.com")
+	if err != nil || !ip.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("unexpected result %v, %v", ip, err)
+	}
+
+	if _, _, err = Observed(&testResolver{err: failure}, observer).Resolve(ctx, "example.com"); !errors.Is(err, failure) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if observer.lookups != 2 || observer.failed != 1 {
+		t.Errorf("unexpected observer state: %+v", observer)
+	}
+}
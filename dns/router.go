@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/internal/domain"
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+// ErrRouteFile is returned when the hosts or rules file content is invalid.
+var ErrRouteFile = errors.New("invalid DNS routing file")
+
+// route is a resolver for names with the given domain suffix.
+type route struct {
+	suffix   string
+	resolver socks5.NameResolver
+}
+
+// routeTable is an immutable snapshot of static hosts and suffix routes.
+type routeTable struct {
+	hosts  map[string]net.IP
+	routes []route // sorted by suffix length, the longest is first
+}
+
+// Router is a name resolver with static hosts and split-horizon rules by domain suffix.
+// Names without a static host and a matched route are resolved by the fallback resolver.
+type Router struct {
+	hostsFile string
+	rulesFile string
+	transport Transport
+	timeout   time.Duration
+	fallback  socks5.NameResolver
+	table     atomic.Pointer[routeTable]
+	logger    *slog.Logger
+}
+
+// NewRouter returns a new Router that reads static hosts from hostsFile and suffix rules from rulesFile.
+// Any of the files can be empty, then it is ignored.
+func NewRouter(
+	hostsFile, rulesFile string,
+	fallback socks5.NameResolver,
+	transport Transport,
+	timeout time.Duration,
+	logger *slog.Logger,
+) (*Router, error) {
+	r := &Router{
+		hostsFile: hostsFile,
+		rulesFile: rulesFile,
+		transport: transport,
+		timeout:   timeout,
+		fallback:  fallback,
+		logger:    logger,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads hosts and rules files again and replaces the current routing table.
+// The old table is kept if any file is invalid.
+func (r *Router) Reload() error {
+	hosts, err := readLines(r.hostsFile, parseHost)
+	if err != nil {
+		return err
+	}
+
+	routes, err := readLines(r.rulesFile, r.parseRoute)
+	if err != nil {
+		return err
+	}
+
+	table := &routeTable{hosts: make(map[string]net.IP, len(hosts))}
+	for _, h := range hosts {
+		for _, name := range h.names {
+			if _, ok := table.hosts[name]; !ok {
+				table.hosts[name] = h.ip // the first record wins like in /etc/hosts
+			}
+		}
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].suffix) > len(routes[j].suffix)
+	})
+	table.routes = routes
+
+	r.table.Store(table)
+	r.logger.Info("DNS router loaded", "hosts", len(table.hosts), "rules", len(table.routes))
+	return nil
+}
+
+// Resolve resolves the given host name using static hosts, suffix rules or the fallback resolver.
+func (r *Router) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	var (
+		table = r.table.Load()
+		fqdn  = domain.Normalize(name)
+	)
+
+	if ip, ok := table.hosts[fqdn]; ok {
+		r.logger.Debug("static host resolved", "dest", fqdn, "ip", ip)
+		return ctx, ip, nil
+	}
+
+	for _, rt := range table.routes {
+		if domain.MatchSuffix(fqdn, rt.suffix) {
+			r.logger.Debug("name is resolved by rule", "dest", fqdn, "rule", rt.suffix)
+			return rt.resolver.Resolve(ctx, name)
+		}
+	}
+
+	return r.fallback.Resolve(ctx, name)
+}
+
+// hostRecord is one line of a hosts file.
+type hostRecord struct {
+	ip    net.IP
+	names []string
+}
+
+// parseHost parses a hosts file line "IP name [name...]".
+func parseHost(fields []string) (hostRecord, error) {
+	if len(fields) < 2 {
+		return hostRecord{}, fmt.Errorf("expected IP and at least one name, got %q", strings.Join(fields, " "))
+	}
+
+	ip := net.ParseIP(fields[0])
+	if ip == nil {
+		return hostRecord{}, fmt.Errorf("invalid IP address %q", fields[0])
+	}
+
+	names := make([]string, 0, len(fields)-1)
+	for _, name := range fields[1:] {
+		names = append(names, domain.Normalize(name))
+	}
+
+	return hostRecord{ip: ip, names: names}, nil
+}
+
+// parseRoute parses a rules file line "suffix DNS-server".
+func (r *Router) parseRoute(fields []string) (route, error) {
+	if len(fields) != 2 {
+		return route{}, fmt.Errorf("expected domain suffix and DNS server, got %q", strings.Join(fields, " "))
+	}
+
+	address, err := ParseAddress(fields[1])
+	if err != nil {
+		return route{}, err
+	}
+
+	suffix := domain.ParseSuffix(fields[0])
+	return route{suffix: suffix, resolver: newNameResolver(address, r.transport, r.timeout, r.logger)}, nil
+}
+
+// readLines parses record lines of the file by the parse function, the empty file name has no lines.
+func readLines[T any](fileName string, parse func([]string) (T, error)) ([]T, error) {
+	if fileName == "" {
+		return nil, nil
+	}
+
+	items, err := lines.ReadAll(fileName, parse)
+	if err != nil {
+		return nil, errors.Join(ErrRouteFile, err)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+)
+
+// staticResolver is a test resolver that always returns the same IP.
+type staticResolver net.IP
+
+func (sr staticResolver) Resolve(ctx context.Context, _ string) (context.Context, net.IP, error) {
+	return ctx, net.IP(sr), nil
+}
+
+func tempFile(t *testing.T, content string) string {
+	f, err := os.CreateTemp("", "dns_gsocks5_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if e := os.Remove(f.Name()); e != nil {
+			t.Error(e)
+		}
+	})
+	return f.Name()
+}
+
+func TestNewRouter(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hosts  string
+		rules  string
+		routes int
+		err    bool
+	}{
+		{name: "empty"},
+		{name: "hosts", hosts: "# comment\n10.0.0.1 a.example.com b.example.com\n\n::1 c.example.com # ipv6\n"},
+		{name: "rules", rules: "corp.internal 10.0.0.53\n*.example.com 8.8.8.8\n", routes: 2},
+		{name: "rulesPort", rules: "corp.internal 127.0.0.1:5353\nlan [::1]:5353\n", routes: 2},
+		{name: "badHostIP", hosts: "bad a.example.com\n", err: true},
+		{name: "badHostName", hosts: "10.0.0.1\n", err: true},
+		{name: "badRule", rules: "corp.internal\n", err: true},
+		{name: "badRuleIP", rules: "corp.internal bad\n", err: true},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			var hostsFile, rulesFile string
+
+			if tc.hosts != "" {
+				hostsFile = tempFile(t, tc.hosts)
+			}
+			if tc.rules != "" {
+				rulesFile = tempFile(t, tc.rules)
+			}
+
+			r, err := NewRouter(hostsFile, rulesFile, staticResolver{}, TransportUDPFallback, timeout, logger)
+			if err != nil {
+				if !tc.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if tc.err {
+				t.Fatal("expected error")
+			}
+
+			if n := len(r.table.Load().routes); n != tc.routes {
+				t.Errorf("expected %d routes, got %d", tc.routes, n)
+			}
+		})
+	}
+}
+
+func TestRouter_Resolve(t *testing.T) {
+	var (
+		fallback = net.ParseIP("192.168.1.1")
+		corp     = net.ParseIP("10.0.0.1")
+		dev      = net.ParseIP("10.0.1.1")
+	)
+
+	hostsFile := tempFile(t, "172.16.0.1 pinned.example.com\n")
+	r, err := NewRouter(hostsFile, "", staticResolver(fallback), TransportUDPFallback, timeout, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.table.Load().routes = []route{
+		{suffix: "dev.corp.internal", resolver: staticResolver(dev)},
+		{suffix: "corp.internal", resolver: staticResolver(corp)},
+	}
+
+	testCases := []struct {
+		name string
+		host string
+		want net.IP
+	}{
+		{name: "static", host: "pinned.example.com", want: net.ParseIP("172.16.0.1")},
+		{name: "staticCase", host: "Pinned.Example.Com.", want: net.ParseIP("172.16.0.1")},
+		{name: "suffix", host: "git.corp.internal", want: corp},
+		{name: "suffixEqual", host: "corp.internal", want: corp},
+		{name: "longestSuffix", host: "app.dev.corp.internal", want: dev},
+		{name: "notSubdomain", host: "notcorp.internal", want: fallback},
+		{name: "fallback", host: "github.com", want: fallback},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			_, ip, e := r.Resolve(context.Background(), tc.host)
+			if e != nil {
+				t.Fatalf("unexpected error: %v", e)
+			}
+
+			if !ip.Equal(tc.want) {
+				t.Errorf("expected %s, got %s", tc.want, ip)
+			}
+		})
+	}
+}
+
+func TestRouter_Reload(t *testing.T) {
+	hostsFile := tempFile(t, "10.0.0.1 a.example.com\n")
+
+	r, err := NewRouter(hostsFile, "", staticResolver{}, TransportUDPFallback, timeout, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(hostsFile, []byte("10.0.0.2 a.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, ip, err := r.Resolve(context.Background(), "a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := net.ParseIP("10.0.0.2"); !ip.Equal(want) {
+		t.Errorf("expected %s, got %s", want, ip)
+	}
+
+	// invalid content keeps the previous table
+	if err = os.WriteFile(hostsFile, []byte("bad\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Reload(); err == nil {
+		t.Error("expected error")
+	}
+
+	if _, ok := r.table.Load().hosts["a.example.com"]; !ok {
+		t.Error("expected previous hosts table")
+	}
+}
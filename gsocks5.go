@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
-	"net"
+	"log/slog"
 	"os"
 	"os/signal"
 	"runtime"
@@ -13,12 +13,13 @@ import (
 	"time"
 	_ "time/tzdata"
 
-	"github.com/armon/go-socks5"
-
+	"github.com/z0rr0/gsocks5/accesslog"
 	"github.com/z0rr0/gsocks5/args"
 	"github.com/z0rr0/gsocks5/auth"
-	"github.com/z0rr0/gsocks5/conn"
-	"github.com/z0rr0/gsocks5/dns"
+	"github.com/z0rr0/gsocks5/limit"
+	"github.com/z0rr0/gsocks5/logfile"
+	"github.com/z0rr0/gsocks5/metrics"
+	"github.com/z0rr0/gsocks5/quota"
 	"github.com/z0rr0/gsocks5/server"
 )
 
@@ -33,47 +34,82 @@ var (
 	BuildDate = ""
 	// GoVersion is runtime Go language version
 	GoVersion = runtime.Version()
-
-	logInfo  = log.New(os.Stdout, name+" [INFO]: ", log.LstdFlags)
-	logDebug = log.New(io.Discard, name+" [DEBUG]: ", log.LstdFlags|log.Lshortfile|log.Lmicroseconds)
 )
 
 func main() {
 	var (
-		authFile    string
-		customDNS   string
-		host        string
-		version     bool
-		debugMode   bool
-		connections uint32 = 1024
-		port        uint16 = 1080
-
-		// timeouts
-		readWriteDeadline = 2 * time.Minute
-		timeoutDNS        = 5 * time.Second
-		timeoutKeepAlive  = 5 * time.Minute
-		timeoutConn       = 15 * time.Second
+		quotaState    string
+		quotasFile    string
+		quotaCut      bool
+		listenersFile string
+		adminAddr     string
+		adminAuth     string
+		readyName     = "example.com"
+		version       bool
+		debugMode     bool
+		logFormatName = logText
+		logLevel      = slog.LevelInfo
+		logFile       = stdout
+		rotation      = logfile.Rotation{Backups: 7}
+		accessLog     string
+		accessFormat  = accesslog.DefaultFormat
+		quotaSave     = time.Minute
+		drain         = 25 * time.Second
+		defaults      = defaultSettings()
 	)
 	defer func() {
 		if r := recover(); r != nil {
-			logInfo.Printf("abnormal termination [%v]: %v\n", Version, r)
+			logger.Error("abnormal termination", "version", Version, "panic", r)
 		}
 	}()
 
-	flag.StringVar(&customDNS, "dns", customDNS, "custom DNS server")
+	defaults.define(flag.CommandLine)
 	flag.BoolVar(&version, "version", false, "show version")
-	flag.StringVar(&host, "host", "", "server host")
-	flag.DurationVar(&readWriteDeadline, "rwd", readWriteDeadline, "read/write deadline timeout")
-	flag.DurationVar(&timeoutDNS, "td", timeoutDNS, "dns timeout")
-	flag.DurationVar(&timeoutKeepAlive, "tk", timeoutKeepAlive, "keepalive timeout")
-	flag.DurationVar(&timeoutConn, "tc", timeoutConn, "connection timeout")
-	flag.BoolVar(&debugMode, "debug", false, "debug mode")
-	flag.Func("port", args.PortDescription(port), func(s string) error { return args.IsPort(s, &port) })
-	flag.Func("auth", "authentication file", func(s string) error { return args.IsFile(s, &authFile) })
-	flag.Func("connections", args.ConcurrentDescription(connections), func(s string) error {
-		return args.IsConcurrent(s, &connections)
+	flag.DurationVar(&drain, "drain", drain, "shutdown waiting time of active sessions, 0 waits all of them")
+	flag.BoolVar(&debugMode, "debug", false, "debug mode, the same as -log-level debug")
+	flag.TextVar(&logLevel, "log-level", logLevel, "log level: debug, info, warn or error")
+	flag.Func("log-format", "log format: text (default) or json", func(s string) error {
+		return logFormat(s, &logFormatName)
+	})
+	flag.StringVar(&logFile, "log-file", logFile, "log file, \"-\" is stdout")
+	flag.Func("log-max-size", "max log file size with K, M or G suffix, 0 disables rotation by size",
+		func(s string) error {
+			var err error
+			rotation.MaxSize, err = limit.ParseSize(s)
+			return err
+		},
+	)
+	flag.DurationVar(&rotation.MaxAge, "log-max-age", 0, "max log file age, 0 disables rotation by age")
+	flag.IntVar(&rotation.Backups, "log-backups", rotation.Backups, "number of compressed log backups, 0 keeps all")
+	flag.StringVar(&accessLog, "access-log", "", "access log file of completed sessions, \"-\" is stdout")
+	flag.StringVar(&accessFormat, "access-log-format", accessFormat, "access log line template or json")
+	flag.StringVar(&quotaState, "quota-state", "", "traffic accounting state file, it enables users' traffic counters")
+	flag.Func("quotas", "users traffic quotas file", func(s string) error { return args.IsFile(s, &quotasFile) })
+	flag.BoolVar(&quotaCut, "quota-cut", false, "close active sessions when the user's quota is exhausted")
+	flag.DurationVar(&quotaSave, "quota-save", quotaSave, "traffic accounting state saving period")
+	flag.StringVar(&adminAddr, "admin", "", "admin HTTP address of metrics and health checks, e.g. 127.0.0.1:9100")
+	flag.Func("admin-auth", "admin API credentials file, it enables sessions API", func(s string) error {
+		return args.IsFile(s, &adminAuth)
+	})
+	flag.StringVar(&readyName, "ready-dns", readyName, "domain name of DNS readiness checks, empty disables them")
+	flag.Func("listeners", "listeners file, other flags are defaults for its listeners", func(s string) error {
+		return args.IsFile(s, &listenersFile)
 	})
 
+	if len(os.Args) > 1 && os.Args[1] == quotaCommand {
+		if err := quotaReport(os.Args[2:]); err != nil {
+			fatal("failed to report traffic", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == healthCommand {
+		if err := healthCheck(os.Args[2:]); err != nil {
+			fatal("health check failed", err)
+		}
+		return
+	}
+
 	flag.Parse()
 
 	versionInfo := fmt.Sprintf("%v: %v %v %v %v", name, Version, Revision, GoVersion, BuildDate)
@@ -82,52 +118,178 @@ func main() {
 		return
 	}
 	if debugMode {
-		logDebug.SetOutput(os.Stdout)
+		logLevel = slog.LevelDebug
 	}
 
-	credentials, err := auth.New(authFile, logInfo)
+	w, appLog, err := openLog(logFile, rotation)
 	if err != nil {
-		logInfo.Fatal(err)
+		fatal("failed to open log file", err)
 	}
+	logger = newLogger(w, logFormatName, logLevel)
+	slog.SetDefault(logger)
 
-	resolver, err := dns.New(customDNS, timeoutDNS, logInfo, logDebug)
+	var reopeners []func() error // log files are reopened on SIGHUP after their rotation by external tools
+	if appLog != nil {
+		defer func() { _ = appLog.Close() }() // nowhere to report the error
+		reopeners = append(reopeners, appLog.Reopen)
+	}
+
+	listeners := []*settings{defaults}
+	if listenersFile != "" {
+		if listeners, err = readListeners(listenersFile, defaults); err != nil {
+			fatal("failed to read listeners", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	env := &listenerEnv{ctx: ctx, readyName: readyName, reloaders: reopeners}
+	if adminAddr != "" {
+		env.registry = metrics.NewRegistry()
+	}
+
+	var closers []func() error
+
+	if accessLog != "" {
+		accessWriter, accessFile, logErr := openLog(accessLog, rotation)
+		if logErr != nil {
+			fatal("failed to open access log", logErr)
+		}
+
+		if env.access, logErr = accesslog.New(accessWriter, accessFormat, logger); logErr != nil {
+			fatal("failed to create access log", logErr)
+		}
+
+		if accessFile != nil {
+			closers = append(closers, accessFile.Close)
+			env.reloaders = append(env.reloaders, accessFile.Reopen)
+		}
+	}
+
+	if quotaState != "" {
+		accountant, quotaErr := quota.New(quotaState, quotasFile, quotaCut, logger)
+		if quotaErr != nil {
+			fatal("failed to start traffic accounting", quotaErr)
+		}
+
+		saveCtx, stopSave := context.WithCancel(ctx)
+		saving := make(chan struct{})
+		go func() {
+			defer close(saving)
+			accountant.Run(saveCtx, quotaSave)
+		}()
+
+		env.accountant = accountant
+		closers = append(closers, func() error {
+			// the periodic saving writes the same temporary file, so it is stopped before the final one
+			stopSave()
+			<-saving
+			return accountant.Save()
+		})
+	}
+
+	preOpened, ready, err := server.Inherited()
 	if err != nil {
-		logInfo.Fatal(err)
+		fatal("failed to inherit listeners", err)
+	}
+
+	if preOpened == nil {
+		if preOpened, err = server.SystemdListeners(); err != nil {
+			fatal("failed to get systemd listeners", err)
+		}
+		if n := len(preOpened); n > 0 {
+			logger.Info("systemd socket activation", "listeners", n)
+		}
+	}
+
+	group := server.NewGroup(logger)
+	if env.accountant != nil {
+		// the new process loads the state at start, saves of both processes merge their traffic later
+		group.BeforeUpgrade = func() {
+			if saveErr := env.accountant.Save(); saveErr != nil {
+				logger.Error("failed to save traffic state before upgrade", "error", saveErr)
+			}
+		}
+	}
+
+	logger.Info(
+		"starting", "version", Version, "revision", Revision, "go", GoVersion, "build", BuildDate,
+		"listeners", len(listeners), "log_level", logLevel.String(), "drain", drain,
+	)
+
+	for _, st := range listeners {
+		s, params, buildErr := env.build(st)
+		if buildErr != nil {
+			fatal(fmt.Sprintf("failed to build listener %q", st.name), buildErr)
+		}
+
+		params.Drain = drain
+		params.Listener, preOpened = server.TakeListener(preOpened, params.Addr, len(listeners) == 1)
+		if params.Listener != nil {
+			logger.Info("pre-opened listener", "listener", st.name, "addr", params.Listener.Addr().String())
+		}
+
+		group.Add(s, params)
 	}
 
-	dialer := &net.Dialer{Timeout: timeoutConn, KeepAlive: timeoutKeepAlive}
-	cfg := &socks5.Config{
-		Logger:      logInfo,
-		Credentials: credentials,
-		Resolver:    resolver,
-		Dial:        conn.Dial(dialer, readWriteDeadline, logInfo),
+	for _, l := range preOpened {
+		logger.Warn("pre-opened listener is not configured, it is closed", "addr", l.Addr().String(), "error", l.Close())
+	}
+
+	if adminAddr != "" {
+		var api *sessionsAPI
+		if adminAuth != "" {
+			credentials, err := auth.New(adminAuth, logger)
+			if err != nil {
+				fatal("failed to read admin API credentials", err)
+			}
+			if credentials == nil {
+				fatal("failed to read admin API credentials", errors.New("no credentials"))
+			}
+			api = &sessionsAPI{servers: env.servers, credentials: credentials}
+		}
+		adminServer := newAdminServer(adminAddr, env.registry, env.checks, api)
+
+		go listenHTTP(ctx, adminServer)
+		closers = append(closers, adminServer.Close)
 	}
 
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, os.Interrupt, os.Signal(syscall.SIGTERM), os.Signal(syscall.SIGQUIT))
 	defer close(sigint)
 
-	s, err := server.New(cfg, logInfo, logDebug)
-	if err != nil {
-		logInfo.Fatal(err)
-	}
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go reload(sighup, env.reloaders)
 
-	addr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
-	logInfo.Println(versionInfo)
+	upgrade := make(chan os.Signal, 1)
+	if len(upgradeSignals) > 0 {
+		signal.Notify(upgrade, upgradeSignals...)
+	}
 
-	logInfo.Printf(
-		"timeouts: read/write=%v, dns=%v, keepalive=%v, connection=%v\n",
-		readWriteDeadline, timeoutDNS, timeoutKeepAlive, timeoutConn,
-	)
-	logInfo.Printf(
-		"starting server on %q, dns=%q, connections=%d, debug=%v, auth=%q\n",
-		addr, customDNS, connections, debugMode, authFile,
-	)
+	if err = group.ListenAndServe(sigint, upgrade, ready); err != nil {
+		logger.Error("server listen error", "error", err)
+	}
 
-	params := &server.Params{Addr: addr, Connections: connections, Sigint: sigint, Timeout: timeoutConn}
-	if err = s.ListenAndServe(params); err != nil {
-		logInfo.Printf("server listen error: %s", err)
+	for _, c := range closers {
+		if err = c(); err != nil {
+			logger.Error("failed to close", "error", err)
+		}
 	}
 
-	logInfo.Println("server stopped")
+	logger.Info("server stopped")
+}
+
+// reload calls all reloaders on every signal from the channel.
+func reload(signals <-chan os.Signal, reloaders []func() error) {
+	for sig := range signals {
+		logger.Info("taken signal, reloading", "signal", sig.String())
+
+		for _, r := range reloaders {
+			if err := r(); err != nil {
+				logger.Error("failed to reload", "error", err)
+			}
+		}
+	}
 }
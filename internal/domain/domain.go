@@ -0,0 +1,29 @@
+// Package domain implements domain names normalization and suffix matching for rules files.
+package domain
+
+import "strings"
+
+// Normalize returns lower-case domain name without the trailing dot.
+func Normalize(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// ParseSuffix returns a normalized domain suffix of rules value "example.com", ".example.com" or "*.example.com".
+// The empty result means any domain.
+func ParseSuffix(value string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(Normalize(value), "*"), ".")
+}
+
+// MatchSuffix returns true if the normalized name is equal to the suffix or is its subdomain.
+// The empty suffix matches any name.
+func MatchSuffix(name, suffix string) bool {
+	if suffix == "" {
+		return true
+	}
+
+	if !strings.HasSuffix(name, suffix) {
+		return false
+	}
+
+	return len(name) == len(suffix) || name[len(name)-len(suffix)-1] == '.'
+}
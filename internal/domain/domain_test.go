@@ -0,0 +1,43 @@
+package domain
+
+import "testing"
+
+func TestParseSuffix(t *testing.T) {
+	testCases := []struct {
+		value  string
+		suffix string
+	}{
+		{value: "Example.COM.", suffix: "example.com"},
+		{value: ".example.com", suffix: "example.com"},
+		{value: "*.example.com", suffix: "example.com"},
+		{value: "*", suffix: ""},
+		{value: ".", suffix: ""},
+	}
+
+	for _, tc := range testCases {
+		if suffix := ParseSuffix(tc.value); suffix != tc.suffix {
+			t.Errorf("value %q: expected %q, got %q", tc.value, tc.suffix, suffix)
+		}
+	}
+}
+
+func TestMatchSuffix(t *testing.T) {
+	testCases := []struct {
+		name   string
+		suffix string
+		match  bool
+	}{
+		{name: "example.com", suffix: "example.com", match: true},
+		{name: "www.example.com", suffix: "example.com", match: true},
+		{name: "badexample.com", suffix: "example.com"},
+		{name: "example.org", suffix: "example.com"},
+		{name: "com", suffix: "example.com"},
+		{name: "example.com", suffix: "", match: true},
+	}
+
+	for _, tc := range testCases {
+		if match := MatchSuffix(tc.name, tc.suffix); match != tc.match {
+			t.Errorf("name %q, suffix %q: expected %v", tc.name, tc.suffix, tc.match)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Package lines reads rules files with one record of space-separated fields per line.
+// Empty lines and comments started with # are skipped.
+package lines
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Read parses fields of every record line of the file by the parse function.
+func Read(fileName string, parse func(fields []string) error) error {
+	f, err := os.Open(filepath.Clean(fileName))
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	if err = errors.Join(Scan(f, parse), f.Close()); err != nil {
+		return fmt.Errorf("failed to read file %s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+// ReadAll returns items parsed from every record line of the file.
+func ReadAll[T any](fileName string, parse func(fields []string) (T, error)) ([]T, error) {
+	var items []T
+
+	err := Read(fileName, func(fields []string) error {
+		item, err := parse(fields)
+		if err != nil {
+			return err
+		}
+
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Scan parses fields of every record line from the reader by the parse function.
+// Parsing errors have the line number.
+func Scan(reader io.Reader, parse func(fields []string) error) error {
+	var (
+		lineNum int
+		scanner = bufio.NewScanner(reader)
+	)
+
+	for scanner.Scan() {
+		lineNum++
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if err := parse(fields); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+
+	return scanner.Err()
+}
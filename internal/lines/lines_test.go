@@ -0,0 +1,60 @@
+package lines
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	var records [][]string
+
+	content := "# comment\n\na b # tail\n  c\td  \n#\n"
+	err := Scan(strings.NewReader(content), func(fields []string) error {
+		records = append(records, fields)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := [][]string{{"a", "b"}, {"c", "d"}}; !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %v, got %v", expected, records)
+	}
+
+	errInvalid := errors.New("invalid")
+	err = Scan(strings.NewReader("a\n\nb\n"), func(fields []string) error {
+		if fields[0] == "b" {
+			return errInvalid
+		}
+		return nil
+	})
+	if !errors.Is(err, errInvalid) || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "rules.txt")
+	if err := os.WriteFile(fileName, []byte("a 1\nb 2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := ReadAll(fileName, func(fields []string) (string, error) {
+		return fields[0] + fields[1], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"a1", "b2"}; !reflect.DeepEqual(items, expected) {
+		t.Errorf("expected %v, got %v", expected, items)
+	}
+
+	if _, err = ReadAll(fileName+".absent", func([]string) (string, error) { return "", nil }); err == nil {
+		t.Error("expected error for absent file")
+	}
+}
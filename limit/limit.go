@@ -0,0 +1,142 @@
+package limit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRate is returned when the rate or size value is invalid.
+var ErrRate = errors.New("invalid rate value")
+
+// Bucket is a token bucket rate limiter.
+// Reservations can take more tokens than available, then the debt delays next reservations,
+// so concurrent consumers are served in order of their requests.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a new full bucket with the rate of tokens per second and the burst size.
+// The burst is equal to the rate if it is not positive.
+func NewBucket(rate, burst int64) *Bucket {
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &Bucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Burst returns the bucket burst size.
+func (b *Bucket) Burst() int {
+	return int(b.burst)
+}
+
+// Reserve takes n tokens from the bucket and returns a duration to wait before using them.
+func (b *Bucket) Reserve(n int) time.Duration {
+	return b.reserve(n, time.Now())
+}
+
+// Allow takes one token if it is available and reports whether it was taken.
+func (b *Bucket) Allow() bool {
+	return b.allow(time.Now())
+}
+
+// refill adds tokens for the time passed since the last call, it must be called with the lock held.
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+func (b *Bucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (b *Bucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Rate is a pair of download and upload rates in bytes per second, zero value means no limit.
+type Rate struct {
+	Down int64
+	Up   int64
+}
+
+// String returns the rate as "DOWN/UP" bytes per second.
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%d", r.Down, r.Up)
+}
+
+// ParseRate parses "DOWN/UP" or "BOTH" value where items are sizes like 512K or 10M per second.
+func ParseRate(value string) (Rate, error) {
+	down, up, found := strings.Cut(value, "/")
+	if !found {
+		up = down
+	}
+
+	d, err := ParseSize(down)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	u, err := ParseSize(up)
+	if err != nil {
+		return Rate{}, err
+	}
+
+	return Rate{Down: d, Up: u}, nil
+}
+
+// ParseSize parses a size in bytes with optional K, M or G binary suffix.
+func ParseSize(value string) (int64, error) {
+	var (
+		multiplier int64 = 1
+		s                = strings.ToUpper(strings.TrimSpace(value))
+	)
+
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Join(ErrRate, fmt.Errorf("invalid size %q", value))
+	}
+
+	return n * multiplier, nil
+}
@@ -0,0 +1,128 @@
+package limit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucket_Reserve(t *testing.T) {
+	var (
+		b   = NewBucket(1000, 100)
+		now = b.last
+	)
+
+	if n := b.Burst(); n != 100 {
+		t.Errorf("expected burst 100, got %d", n)
+	}
+
+	if d := b.reserve(100, now); d != 0 {
+		t.Errorf("expected no delay for burst, got %v", d)
+	}
+
+	if d := b.reserve(100, now); d != 100*time.Millisecond {
+		t.Errorf("expected 100ms delay, got %v", d)
+	}
+
+	// the debt is paid in 100ms, next 50ms gives 50 tokens
+	if d := b.reserve(50, now.Add(150*time.Millisecond)); d != 0 {
+		t.Errorf("expected no delay, got %v", d)
+	}
+
+	// tokens are not accumulated more than burst
+	if d := b.reserve(200, now.Add(time.Hour)); d != 100*time.Millisecond {
+		t.Errorf("expected 100ms delay, got %v", d)
+	}
+
+	if b = NewBucket(10, 0); b.Burst() != 10 {
+		t.Errorf("expected default burst 10, got %d", b.Burst())
+	}
+}
+
+func TestBucket_Allow(t *testing.T) {
+	var (
+		b   = NewBucket(10, 2)
+		now = b.last
+	)
+
+	for i := range 2 {
+		if !b.allow(now) {
+			t.Errorf("expected allowed token %d", i)
+		}
+	}
+
+	if b.allow(now) {
+		t.Error("expected empty bucket")
+	}
+
+	// 10 tokens per second, one token in 100ms
+	if b.allow(now.Add(50 * time.Millisecond)) {
+		t.Error("expected not enough tokens")
+	}
+
+	if !b.allow(now.Add(100 * time.Millisecond)) {
+		t.Error("expected refilled token")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{value: "0"},
+		{value: "100", want: 100},
+		{value: "512K", want: 512 << 10},
+		{value: "10m", want: 10 << 20},
+		{value: "1G", want: 1 << 30},
+		{value: "", err: true},
+		{value: "K", err: true},
+		{value: "-1", err: true},
+		{value: "1T", err: true},
+	}
+
+	for _, tc := range testCases {
+		n, err := ParseSize(tc.value)
+		if tc.err {
+			if !errors.Is(err, ErrRate) {
+				t.Errorf("ParseSize(%q) error = %v, want ErrRate", tc.value, err)
+			}
+			continue
+		}
+
+		if err != nil || n != tc.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tc.value, n, err, tc.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		value string
+		want  Rate
+		err   bool
+	}{
+		{value: "1M", want: Rate{Down: 1 << 20, Up: 1 << 20}},
+		{value: "10M/2M", want: Rate{Down: 10 << 20, Up: 2 << 20}},
+		{value: "0/512K", want: Rate{Up: 512 << 10}},
+		{value: "bad/1M", err: true},
+		{value: "1M/bad", err: true},
+	}
+
+	for _, tc := range testCases {
+		r, err := ParseRate(tc.value)
+		if (err != nil) != tc.err {
+			t.Errorf("ParseRate(%q) unexpected error: %v", tc.value, err)
+			continue
+		}
+
+		if r != tc.want {
+			t.Errorf("ParseRate(%q) = %v, want %v", tc.value, r, tc.want)
+		}
+	}
+
+	if s := (Rate{Down: 1, Up: 2}).String(); s != "1/2" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
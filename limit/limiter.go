@@ -0,0 +1,100 @@
+package limit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+// Buckets are download and upload buckets of one limitation level.
+type Buckets struct {
+	Down *Bucket
+	Up   *Bucket
+}
+
+// newBuckets returns buckets for the rate, a bucket is nil if its direction is not limited.
+func newBuckets(rate Rate, burst int64) Buckets {
+	var b Buckets
+
+	if rate.Down > 0 {
+		b.Down = NewBucket(rate.Down, burst)
+	}
+
+	if rate.Up > 0 {
+		b.Up = NewBucket(rate.Up, burst)
+	}
+
+	return b
+}
+
+// Limiter is a bandwidth limiter with global, per-user and per-session levels.
+// Global and users' buckets are shared by all sessions, so they split the bandwidth.
+type Limiter struct {
+	global  Buckets
+	session Rate
+	burst   int64
+	users   map[string]Buckets
+}
+
+// NewLimiter returns a new Limiter with global and session rates
+// and users' rates from the file with lines "user DOWN/UP". The file name can be empty.
+// The burst is a bucket size in bytes, it is equal to the rate if not set.
+func NewLimiter(global, session Rate, usersFile string, burst int64) (*Limiter, error) {
+	l := &Limiter{global: newBuckets(global, burst), session: session, burst: burst}
+
+	if usersFile == "" {
+		return l, nil
+	}
+
+	users := make(map[string]Rate)
+	if err := lines.Read(usersFile, parseUserRates(users)); err != nil {
+		return nil, errors.Join(ErrRate, err)
+	}
+
+	l.users = make(map[string]Buckets, len(users))
+	for user, rate := range users {
+		l.users[user] = newBuckets(rate, burst)
+	}
+
+	return l, nil
+}
+
+// parseUserRates returns a parser of lines "user DOWN/UP" which sets users' rates.
+func parseUserRates(users map[string]Rate) func([]string) error {
+	return func(values []string) error {
+		if len(values) != 2 {
+			return fmt.Errorf("expected user and rate, got %q", strings.Join(values, " "))
+		}
+
+		rate, err := ParseRate(values[1])
+		if err != nil {
+			return err
+		}
+
+		users[values[0]] = rate
+		return nil
+	}
+}
+
+// Session returns all download and upload buckets for a new session of the user.
+// Both results are empty if the session is not limited.
+func (l *Limiter) Session(user string) ([]*Bucket, []*Bucket) {
+	var (
+		down, up []*Bucket
+		levels   = []Buckets{l.global, l.users[user], newBuckets(l.session, l.burst)}
+	)
+
+	for _, b := range levels {
+		if b.Down != nil {
+			down = append(down, b.Down)
+		}
+
+		if b.Up != nil {
+			up = append(up, b.Up)
+		}
+	}
+
+	return down, up
+}
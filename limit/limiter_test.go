@@ -0,0 +1,79 @@
+package limit
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+func TestNewLimiter(t *testing.T) {
+	f, err := os.CreateTemp("", "limit_gsocks5_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := os.Remove(f.Name()); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	if _, err = f.WriteString("# users\nalice 1M/512K\n\nbob 0/1K # upload only\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLimiter(Rate{Down: 10 << 20}, Rate{Down: 1 << 20, Up: 1 << 20}, f.Name(), 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		user string
+		down int
+		up   int
+	}{
+		{user: "", down: 2, up: 1},
+		{user: "alice", down: 3, up: 2},
+		{user: "bob", down: 2, up: 2},
+	}
+
+	for _, tc := range testCases {
+		down, up := l.Session(tc.user)
+		if len(down) != tc.down || len(up) != tc.up {
+			t.Errorf("user %q: expected %d/%d buckets, got %d/%d", tc.user, tc.down, tc.up, len(down), len(up))
+		}
+	}
+
+	// global and user buckets are shared, session ones are not
+	down1, _ := l.Session("alice")
+	down2, _ := l.Session("alice")
+
+	if down1[0] != down2[0] || down1[1] != down2[1] || down1[2] == down2[2] {
+		t.Error("unexpected buckets sharing")
+	}
+
+	if _, err = NewLimiter(Rate{}, Rate{}, "/not/existing/file", 0); err == nil {
+		t.Error("expected error for not existing file")
+	}
+}
+
+func TestParseUserRates(t *testing.T) {
+	for _, content := range []string{"alice\n", "alice 1M 2M\n", "alice bad\n"} {
+		if err := lines.Scan(strings.NewReader(content), parseUserRates(make(map[string]Rate))); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+
+	l, err := NewLimiter(Rate{}, Rate{}, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if down, up := l.Session("alice"); len(down)+len(up) != 0 {
+		t.Error("expected not limited session")
+	}
+}
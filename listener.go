@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/accesslog"
+	"github.com/z0rr0/gsocks5/args"
+	"github.com/z0rr0/gsocks5/auth"
+	"github.com/z0rr0/gsocks5/conn"
+	"github.com/z0rr0/gsocks5/dns"
+	"github.com/z0rr0/gsocks5/limit"
+	"github.com/z0rr0/gsocks5/metrics"
+	"github.com/z0rr0/gsocks5/proxyproto"
+	"github.com/z0rr0/gsocks5/quota"
+	"github.com/z0rr0/gsocks5/server"
+	"github.com/z0rr0/gsocks5/upstream"
+)
+
+// noAuth is a value of auth parameter to disable authentication of a listener.
+const noAuth = "none"
+
+// settings are independent parameters of one listener.
+type settings struct {
+	name         string
+	host         string
+	port         uint16
+	authFile     string
+	peerUsers    string
+	socketMode   os.FileMode
+	socketOwner  string
+	proxyTrusted proxyproto.Trusted
+	proxyOut     string
+	customDNS    string
+	transport    dns.Transport
+	hostsFile    string
+	rulesDNS     string
+	blocklists   []string
+	sourceIPs    []net.IP
+	sourceUsers  string
+	strategy     conn.Strategy
+	upstreams    string
+	bwGlobal     limit.Rate
+	bwSession    limit.Rate
+	bwUsers      string
+	bwBurst      int64
+	timeouts     string
+	connections  uint32
+	ipConns      uint32
+	prefixConns  uint32
+	acceptRate   uint32
+	acceptIP     uint32
+	overflow     server.Overflow
+	queueTimeout time.Duration
+	queueSize    uint32
+
+	// timeouts
+	readWriteDeadline time.Duration
+	timeoutDNS        time.Duration
+	timeoutKeepAlive  time.Duration
+	timeoutConn       time.Duration
+	timeoutHandshake  time.Duration
+	lifetime          time.Duration
+	blocklistRefresh  time.Duration
+}
+
+// defaultSettings returns settings of the default listener.
+func defaultSettings() *settings {
+	return &settings{
+		name:              "default",
+		port:              1080,
+		transport:         dns.TransportUDPFallback,
+		strategy:          conn.StrategyRoundRobin,
+		connections:       1024,
+		overflow:          server.OverflowBlock,
+		queueTimeout:      5 * time.Second,
+		queueSize:         1024,
+		readWriteDeadline: 2 * time.Minute,
+		timeoutDNS:        5 * time.Second,
+		timeoutKeepAlive:  5 * time.Minute,
+		timeoutConn:       15 * time.Second,
+		timeoutHandshake:  15 * time.Second,
+		blocklistRefresh:  time.Hour,
+	}
+}
+
+// clone returns a copy of the settings with the name.
+func (st *settings) clone(name string) *settings {
+	c := *st
+	c.name = name
+	c.blocklists = slices.Clone(st.blocklists)
+	c.sourceIPs = slices.Clone(st.sourceIPs)
+	c.proxyTrusted = slices.Clone(st.proxyTrusted)
+	return &c
+}
+
+// addr returns the listener address, the port is not used for unix sockets.
+func (st *settings) addr() string {
+	if strings.HasPrefix(st.host, server.UnixPrefix) {
+		return st.host
+	}
+
+	return net.JoinHostPort(st.host, strconv.FormatUint(uint64(st.port), 10))
+}
+
+// define defines listener flags, current settings values are defaults.
+func (st *settings) define(fs *flag.FlagSet) {
+	fs.StringVar(&st.customDNS, "dns", st.customDNS, "custom DNS server IP or IP:port")
+	fs.Func("dns-transport", "DNS transport: udp, tcp or udp-tcp (default udp-tcp)", func(s string) (err error) {
+		st.transport, err = dns.ParseTransport(s)
+		return err
+	})
+	fs.StringVar(&st.host, "host", st.host, "server host or unix socket path with \""+server.UnixPrefix+"\" prefix")
+	fs.Func("socket-mode", "unix socket file mode, e.g. 0660", func(s string) error {
+		return args.IsFileMode(s, &st.socketMode)
+	})
+	fs.StringVar(&st.socketOwner, "socket-owner", st.socketOwner, "unix socket file owner user[:group]")
+	fs.Func("proxy-protocol", "comma-separated trusted sources of PROXY protocol headers", func(s string) (err error) {
+		st.proxyTrusted, err = proxyproto.ParseTrusted(s)
+		return err
+	})
+	fs.Func("proxy-out", "destinations file of outbound PROXY protocol headers", func(s string) error {
+		return args.IsFile(s, &st.proxyOut)
+	})
+	fs.Func("peer-users", "unix socket clients UID to user mapping file", func(s string) error {
+		return args.IsFile(s, &st.peerUsers)
+	})
+	fs.DurationVar(&st.readWriteDeadline, "rwd", st.readWriteDeadline, "session idle timeout in both directions")
+	fs.DurationVar(&st.timeoutHandshake, "th", st.timeoutHandshake, "client handshake and authentication timeout")
+	fs.DurationVar(&st.lifetime, "tl", st.lifetime, "max session lifetime, no limit by default")
+	fs.Func("timeouts", "users session timeouts file", func(s string) error { return args.IsFile(s, &st.timeouts) })
+	fs.DurationVar(&st.timeoutDNS, "td", st.timeoutDNS, "dns timeout")
+	fs.DurationVar(&st.timeoutKeepAlive, "tk", st.timeoutKeepAlive, "keepalive timeout")
+	fs.DurationVar(&st.timeoutConn, "tc", st.timeoutConn, "connection timeout")
+	fs.DurationVar(&st.blocklistRefresh, "blocklist-refresh", st.blocklistRefresh, "blocklists refresh period")
+	fs.Func("port", args.PortDescription(st.port), func(s string) error { return args.IsPort(s, &st.port) })
+	fs.Func("ip-connections", "concurrent connections per client IP, no limit by default", func(s string) error {
+		return args.IsConcurrent(s, &st.ipConns)
+	})
+	fs.Func("prefix-connections", "concurrent connections per client /24 or /64 network", func(s string) error {
+		return args.IsConcurrent(s, &st.prefixConns)
+	})
+	fs.Func("accept-rate", "new connections per second, no limit by default", func(s string) error {
+		return args.IsConcurrent(s, &st.acceptRate)
+	})
+	fs.Func("accept-rate-ip", "new connections per second from one client IP", func(s string) error {
+		return args.IsConcurrent(s, &st.acceptIP)
+	})
+	fs.Func("overflow", "connections limit policy: block (default), reject or queue", func(s string) (err error) {
+		st.overflow, err = server.ParseOverflow(s)
+		return err
+	})
+	fs.DurationVar(&st.queueTimeout, "queue-timeout", st.queueTimeout, "max waiting time of queued connections")
+	fs.Func("queue-size", "max number of queued connections (default 1024)", func(s string) error {
+		return args.IsConcurrent(s, &st.queueSize)
+	})
+	fs.Func("auth", "authentication file, \""+noAuth+"\" disables inherited one", func(s string) error {
+		if s == noAuth {
+			st.authFile = ""
+			return nil
+		}
+		return args.IsFile(s, &st.authFile)
+	})
+	fs.Func("hosts", "static hosts file, reloaded on SIGHUP", func(s string) error {
+		return args.IsFile(s, &st.hostsFile)
+	})
+	fs.Func("dns-rules", "DNS server rules file by domain suffix, reloaded on SIGHUP", func(s string) error {
+		return args.IsFile(s, &st.rulesDNS)
+	})
+	fs.Func("source", "comma-separated outbound source IP addresses", func(s string) (err error) {
+		st.sourceIPs, err = conn.ParseAddresses(s)
+		return err
+	})
+	fs.Func("source-users", "users outbound source IP addresses file", func(s string) error {
+		return args.IsFile(s, &st.sourceUsers)
+	})
+	fs.Func("source-strategy", "source IP rotation: round-robin (default) or random", func(s string) (err error) {
+		st.strategy, err = conn.ParseStrategy(s)
+		return err
+	})
+	fs.Func("upstream", "upstream proxies and rules file", func(s string) error {
+		return args.IsFile(s, &st.upstreams)
+	})
+	fs.Func("bw-global", "total bandwidth DOWN/UP bytes per second, e.g. 10M/2M", func(s string) (err error) {
+		st.bwGlobal, err = limit.ParseRate(s)
+		return err
+	})
+	fs.Func("bw-session", "session bandwidth DOWN/UP bytes per second, e.g. 1M/512K", func(s string) (err error) {
+		st.bwSession, err = limit.ParseRate(s)
+		return err
+	})
+	fs.Func("bw-users", "users bandwidth file", func(s string) error { return args.IsFile(s, &st.bwUsers) })
+	fs.Func("bw-burst", "bandwidth burst size in bytes (default is equal to rate)", func(s string) (err error) {
+		st.bwBurst, err = limit.ParseSize(s)
+		return err
+	})
+	fs.Func("blocklist", "blocked domains file in hosts or domain list format, can be repeated", func(s string) error {
+		return args.AppendFile(s, &st.blocklists)
+	})
+	fs.Func("connections", args.ConcurrentDescription(st.connections), func(s string) error {
+		return args.IsConcurrent(s, &st.connections)
+	})
+}
+
+// readListeners reads listeners settings from the file, command line settings are defaults for all of them.
+// Every line is a listener name and its flags, empty lines and comments started with # are skipped.
+func readListeners(fileName string, defaults *settings) ([]*settings, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open listeners file: %w", err)
+	}
+	defer func() { _ = f.Close() }() // read only
+
+	var (
+		result  []*settings
+		names   = make(map[string]struct{})
+		scanner = bufio.NewScanner(f)
+	)
+
+	for i := 1; scanner.Scan(); i++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		st, parseErr := parseListener(fields, defaults)
+		if parseErr != nil {
+			return nil, fmt.Errorf("listeners file %s line %d: %w", fileName, i, parseErr)
+		}
+
+		if _, ok := names[st.name]; ok {
+			return nil, fmt.Errorf("listeners file %s line %d: duplicate listener %q", fileName, i, st.name)
+		}
+
+		names[st.name] = struct{}{}
+		result = append(result, st)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read listeners file: %w", err)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no listeners in file %s", fileName)
+	}
+
+	return result, nil
+}
+
+// parseListener returns settings of one listener by its name and flags.
+func parseListener(fields []string, defaults *settings) (*settings, error) {
+	if strings.HasPrefix(fields[0], "-") {
+		return nil, errors.New("listener name is required")
+	}
+
+	st := defaults.clone(fields[0])
+	fs := flag.NewFlagSet(st.name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	st.define(fs)
+
+	if err := fs.Parse(fields[1:]); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	return st, nil
+}
+
+// listenerEnv is a common environment of all listeners.
+type listenerEnv struct {
+	ctx        context.Context // background tasks context
+	accountant *quota.Accountant
+	access     *accesslog.Logger // nil if the access log is disabled
+	registry   *metrics.Registry // nil if metrics are disabled
+	readyName  string            // domain name of DNS readiness checks, empty disables them
+	reloaders  []func() error
+	checks     []readyCheck
+	servers    []namedServer
+}
+
+// build creates a server and its parameters by the listener settings.
+func (env *listenerEnv) build(st *settings) (*server.Server, *server.Params, error) {
+	lg := logger.With("listener", st.name)
+
+	credentials, err := auth.New(st.authFile, lg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolver, err := dns.New(st.customDNS, st.transport, st.timeoutDNS, lg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if st.hostsFile != "" || st.rulesDNS != "" {
+		router, routerErr := dns.NewRouter(
+			st.hostsFile, st.rulesDNS, resolver, st.transport, st.timeoutDNS, lg,
+		)
+		if routerErr != nil {
+			return nil, nil, routerErr
+		}
+
+		resolver = router
+		env.reloaders = append(env.reloaders, router.Reload)
+	}
+
+	if env.readyName != "" {
+		env.checks = append(env.checks, dnsCheck(st, resolver, env.readyName))
+	}
+
+	var observed *listenerMetrics
+	if env.registry != nil {
+		// blocked names are not looked up, so they are not observed
+		observed = newListenerMetrics(env.registry, st.name)
+		resolver = dns.Observed(resolver, observed)
+	}
+
+	dialer := &net.Dialer{Timeout: st.timeoutConn, KeepAlive: st.timeoutKeepAlive}
+
+	var dialOptions []conn.Option
+	if st.upstreams != "" {
+		router, routerErr := upstream.New(st.upstreams, dialer, lg)
+		if routerErr != nil {
+			return nil, nil, routerErr
+		}
+
+		go router.HealthCheck(env.ctx)
+		// names of parent proxies with remote DNS are not resolved locally, but blocklists are checked before
+		resolver = router.Resolver(resolver)
+		dialOptions = append(dialOptions, conn.WithForwarder(router))
+	}
+
+	var rules socks5.RuleSet
+	if len(st.blocklists) > 0 {
+		blocker, blockerErr := dns.NewBlocker(st.blocklists, resolver, lg)
+		if blockerErr != nil {
+			return nil, nil, blockerErr
+		}
+
+		go blocker.Refresh(env.ctx, st.blocklistRefresh)
+		if env.registry != nil {
+			registerBlocker(env.registry, st.name, blocker)
+		}
+
+		resolver = blocker
+		rules = blocker.Rules(rules)
+		env.reloaders = append(env.reloaders, blocker.Reload)
+	}
+
+	if len(st.sourceIPs) > 0 || st.sourceUsers != "" {
+		sources, sourceErr := conn.NewSourcePool(st.sourceIPs, st.sourceUsers, st.strategy)
+		if sourceErr != nil {
+			return nil, nil, sourceErr
+		}
+
+		lg.Info("outbound source addresses", "addresses", st.sourceIPs, "strategy", st.strategy, "users", st.sourceUsers)
+		dialOptions = append(dialOptions, conn.WithSources(sources))
+	}
+
+	if st.proxyOut != "" {
+		headers, headersErr := conn.NewProxyHeaders(st.proxyOut)
+		if headersErr != nil {
+			return nil, nil, headersErr
+		}
+
+		lg.Info("outbound PROXY protocol headers", "file", st.proxyOut)
+		dialOptions = append(dialOptions, conn.WithProxyHeaders(headers))
+	}
+
+	if st.bwGlobal != (limit.Rate{}) || st.bwSession != (limit.Rate{}) || st.bwUsers != "" {
+		limiter, limiterErr := limit.NewLimiter(st.bwGlobal, st.bwSession, st.bwUsers, st.bwBurst)
+		if limiterErr != nil {
+			return nil, nil, limiterErr
+		}
+
+		lg.Info(
+			"bandwidth limits",
+			"global", st.bwGlobal, "session", st.bwSession, "burst", st.bwBurst, "users", st.bwUsers,
+		)
+		dialOptions = append(dialOptions, conn.WithLimiter(limiter))
+	}
+
+	if env.accountant != nil {
+		rules = env.accountant.Rules(rules)
+		dialOptions = append(dialOptions, conn.WithMeter(env.accountant))
+	}
+
+	if observed != nil {
+		dialOptions = append(dialOptions, conn.WithDialObserver(observed), conn.WithMeter(observed))
+	}
+
+	global := conn.Timeouts{Handshake: st.timeoutHandshake, Idle: st.readWriteDeadline, Lifetime: st.lifetime}
+	timeoutPolicy, err := conn.NewTimeoutPolicy(global, st.timeouts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := &socks5.Config{
+		Logger:      slog.NewLogLogger(lg.Handler(), slog.LevelInfo),
+		Credentials: credentials,
+		Resolver:    resolver,
+		Rules:       rules,
+		Dial:        conn.Dial(dialer, st.readWriteDeadline, lg, dialOptions...),
+	}
+
+	if st.peerUsers != "" {
+		peers, peersErr := auth.Peers(st.peerUsers, lg)
+		if peersErr != nil {
+			return nil, nil, peersErr
+		}
+
+		cfg.AuthMethods = server.PeerAuth(cfg, peers)
+	}
+
+	s, err := server.New(cfg, lg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if env.registry != nil {
+		registerServer(env.registry, st.name, s)
+	}
+	env.servers = append(env.servers, namedServer{name: st.name, s: s})
+	env.checks = append(env.checks, readyCheck{
+		name: fmt.Sprintf("listener %q", st.name),
+		check: func(context.Context) error {
+			if !s.Accepting() {
+				return errors.New("not accepting connections")
+			}
+			return nil
+		},
+	})
+
+	lg.Info(
+		"timeouts",
+		"session", global, "dns", st.timeoutDNS, "keepalive", st.timeoutKeepAlive,
+		"connection", st.timeoutConn, "users", st.timeouts,
+	)
+	lg.Info(
+		"listener",
+		"addr", st.addr(), "dns", st.customDNS, "connections", st.connections, "auth", st.authFile,
+	)
+
+	params := &server.Params{
+		Addr:              st.addr(),
+		Connections:       st.connections,
+		IPConnections:     st.ipConns,
+		PrefixConnections: st.prefixConns,
+		AcceptRate:        st.acceptRate,
+		AcceptRateIP:      st.acceptIP,
+		Overflow:          st.overflow,
+		QueueTimeout:      st.queueTimeout,
+		QueueSize:         st.queueSize,
+		SocketMode:        st.socketMode,
+		SocketOwner:       st.socketOwner,
+		ProxyTrusted:      st.proxyTrusted,
+		Timeout:           st.timeoutHandshake,
+		Timeouts:          timeoutPolicy,
+	}
+	if env.access != nil {
+		params.AccessLog = env.access.Listener(st.name)
+	}
+
+	return s, params, nil
+}
+
+// dnsCheck returns a readiness check of the listener resolver by a lookup of the name.
+func dnsCheck(st *settings, resolver socks5.NameResolver, name string) readyCheck {
+	return readyCheck{
+		name: fmt.Sprintf("listener %q DNS", st.name),
+		check: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, st.timeoutDNS)
+			defer cancel()
+
+			_, ip, err := resolver.Resolve(ctx, name)
+			if err == nil && ip == nil {
+				err = fmt.Errorf("no address of %q", name)
+			}
+			return err
+		},
+	}
+}
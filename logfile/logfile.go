@@ -0,0 +1,294 @@
+// Package logfile implements a log file with size and age based rotation and compressed backups.
+package logfile
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTime = "20060102-150405.000" // backup file name suffix, it is sorted by time
+	backupExt  = ".gz"
+	fileMode   = 0o640
+)
+
+// ErrRotate is returned when the log file can not be rotated or reopened.
+var ErrRotate = errors.New("failed to rotate log file")
+
+// Rotation are log file rotation settings, zero values disable rotation by the parameter.
+type Rotation struct {
+	MaxSize int64         // max file size in bytes
+	MaxAge  time.Duration // max time since the file is started, it is not reset by reopening of the existing file
+	Backups int           // number of kept compressed backups, zero keeps all of them
+}
+
+// File is a log file writer, it is safe for concurrent use.
+// Rotated files are renamed with a time suffix and compressed in the background.
+type File struct {
+	name     string
+	rotation Rotation
+	mu       sync.Mutex
+	f        *os.File // nil if the file is not opened again after a failed rotation
+	closed   bool
+	size     int64
+	started  time.Time
+	mill     sync.Mutex     // serializes compression and removal of backups
+	wg       sync.WaitGroup // background compression
+	onError  func(error)    // handler of background compression errors
+	now      func() time.Time
+	openFile func(name string, flag int, perm os.FileMode) (*os.File, error)
+}
+
+// Open opens the log file for appending with the rotation settings.
+// Errors of background compression are passed to the onError handler, they are ignored if it is nil.
+func Open(name string, rotation Rotation, onError func(error)) (*File, error) {
+	if onError == nil {
+		onError = func(error) {}
+	}
+
+	lf := &File{
+		name:     filepath.Clean(name),
+		rotation: rotation,
+		onError:  onError,
+		now:      time.Now,
+		openFile: os.OpenFile,
+	}
+
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+
+	return lf, nil
+}
+
+// open opens the log file and sets its size and age, the caller must hold the lock.
+func (lf *File) open() error {
+	f, err := lf.openFile(lf.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, fileMode)
+	if err != nil {
+		return errors.Join(ErrRotate, fmt.Errorf("failed to open file: %w", err))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Join(ErrRotate, fmt.Errorf("failed to get file info: %w", err), f.Close())
+	}
+
+	lf.f, lf.size, lf.started = f, info.Size(), lf.startTime(info)
+	return nil
+}
+
+// startTime returns the time when the log file was started. It is the time of the newest backup,
+// because the file was created by that rotation, or the file modification time if there are no backups.
+// Empty files are started now.
+func (lf *File) startTime(info os.FileInfo) time.Time {
+	now := lf.now()
+	if info.Size() == 0 {
+		return now
+	}
+
+	started := info.ModTime()
+	if backups, err := lf.Backups(); err == nil && len(backups) > 0 {
+		if rotated, ok := lf.backupTime(backups[len(backups)-1]); ok {
+			started = rotated
+		}
+	}
+
+	if started.After(now) {
+		return now
+	}
+
+	return started
+}
+
+// Write writes data to the file, it rotates the file before the write if it is needed.
+// The file is opened again if it failed after the previous rotation.
+func (lf *File) Write(b []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.closed {
+		return 0, os.ErrClosed
+	}
+
+	if lf.f != nil && lf.expired(int64(len(b))) {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	if lf.f == nil {
+		if err := lf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.f.Write(b)
+	lf.size += int64(n)
+
+	return n, err
+}
+
+// expired returns true if the file is too old or the data does not fit to its max size.
+// Empty files are not rotated.
+func (lf *File) expired(n int64) bool {
+	if lf.size == 0 {
+		return false
+	}
+
+	if lf.rotation.MaxSize > 0 && lf.size+n > lf.rotation.MaxSize {
+		return true
+	}
+
+	return lf.rotation.MaxAge > 0 && lf.now().Sub(lf.started) >= lf.rotation.MaxAge
+}
+
+// rotate renames the current file to a backup, opens a new one and compresses the backup in the background.
+// The file stays not opened if it fails, so the next write tries to open it again.
+func (lf *File) rotate() error {
+	err := lf.f.Close()
+	lf.f = nil
+
+	if err != nil {
+		return errors.Join(ErrRotate, err)
+	}
+
+	backup := lf.name + "." + lf.now().Format(backupTime)
+	if err = os.Rename(lf.name, backup); err != nil {
+		return errors.Join(ErrRotate, err, lf.open())
+	}
+
+	lf.wg.Add(1)
+	go func() {
+		defer lf.wg.Done()
+		lf.compress(backup)
+	}()
+
+	return lf.open()
+}
+
+// compress compresses the backup file and removes old backups.
+func (lf *File) compress(backup string) {
+	lf.mill.Lock()
+	defer lf.mill.Unlock()
+
+	err := compressFile(backup)
+	if err == nil {
+		err = lf.prune()
+	}
+
+	if err != nil {
+		lf.onError(errors.Join(ErrRotate, fmt.Errorf("failed to compress backup %s: %w", backup, err)))
+	}
+}
+
+// compressFile writes the gzip-compressed copy of the file and removes the original one.
+func compressFile(name string) error {
+	src, err := os.Open(filepath.Clean(name))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }() // it is read only
+
+	dst, err := os.OpenFile(name+backupExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		return errors.Join(err, zw.Close(), dst.Close(), os.Remove(dst.Name()))
+	}
+
+	if err = errors.Join(zw.Close(), dst.Close()); err != nil {
+		return errors.Join(err, os.Remove(dst.Name()))
+	}
+
+	return os.Remove(name)
+}
+
+// prune removes the oldest compressed backups over the limit.
+func (lf *File) prune() error {
+	if lf.rotation.Backups <= 0 {
+		return nil
+	}
+
+	backups, err := lf.Backups()
+	if err != nil {
+		return err
+	}
+
+	if n := len(backups) - lf.rotation.Backups; n > 0 {
+		for _, name := range backups[:n] {
+			err = errors.Join(err, os.Remove(name))
+		}
+	}
+
+	return err
+}
+
+// Backups returns names of compressed backups from the oldest to the newest one.
+func (lf *File) Backups() ([]string, error) {
+	names, err := filepath.Glob(lf.name + ".*" + backupExt)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := slices.DeleteFunc(names, func(name string) bool {
+		_, ok := lf.backupTime(name)
+		return !ok
+	})
+
+	slices.Sort(backups)
+	return backups, nil
+}
+
+// backupTime returns the rotation time from the compressed backup name, it is false for other files.
+func (lf *File) backupTime(name string) (time.Time, bool) {
+	suffix := strings.TrimSuffix(strings.TrimPrefix(name, lf.name+"."), backupExt)
+	t, err := time.ParseInLocation(backupTime, suffix, lf.now().Location())
+	return t, err == nil
+}
+
+// Reopen closes and opens the file again, it is used after the file is moved by an external tool.
+func (lf *File) Reopen() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.closed {
+		return os.ErrClosed
+	}
+
+	if lf.f != nil {
+		err := lf.f.Close()
+		lf.f = nil
+
+		if err != nil {
+			return errors.Join(ErrRotate, err)
+		}
+	}
+
+	return lf.open()
+}
+
+// Close closes the file and waits for the background compression.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	f := lf.f
+	lf.f, lf.closed = nil, true
+	lf.mu.Unlock()
+
+	lf.wg.Wait()
+	if f == nil {
+		return nil
+	}
+
+	return f.Close()
+}
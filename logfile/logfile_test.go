@@ -0,0 +1,343 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clock returns a time function that is moved by the test.
+func clock(now *time.Time) func() time.Time {
+	return func() time.Time { return *now }
+}
+
+// readGzip returns the content of the compressed file.
+func readGzip(t *testing.T, name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// readFile returns the file content.
+func readFile(t *testing.T, name string) string {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestFile_RotateSize(t *testing.T) {
+	var (
+		now  = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+		name = filepath.Join(t.TempDir(), "access.log")
+	)
+
+	lf, err := Open(name, Rotation{MaxSize: 10, Backups: 2}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.now = clock(&now)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		now = now.Add(time.Second)
+		if _, err = lf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, name); s != "fourth\n" {
+		t.Errorf("unexpected file content %q", s)
+	}
+
+	backups, err := lf.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first backup is removed
+	if n := len(backups); n != 2 {
+		t.Fatalf("expected 2 backups, got %d: %v", n, backups)
+	}
+
+	if s := readGzip(t, backups[0]); s != "second\n" {
+		t.Errorf("unexpected backup content %q", s)
+	}
+
+	if s := readGzip(t, backups[1]); s != "third\n" {
+		t.Errorf("unexpected backup content %q", s)
+	}
+
+	if _, err = lf.Write([]byte("closed\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected closed file error, got %v", err)
+	}
+}
+
+func TestFile_RotateAge(t *testing.T) {
+	var (
+		now  = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+		name = filepath.Join(t.TempDir(), "gsocks5.log")
+	)
+
+	lf, err := Open(name, Rotation{MaxAge: time.Hour}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.now = clock(&now)
+	lf.started = now
+
+	for _, d := range []time.Duration{0, 30 * time.Minute, 30 * time.Minute, time.Minute} {
+		now = now.Add(d)
+		if _, err = lf.Write([]byte(now.Format(time.Kitchen) + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := lf.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(backups); n != 1 {
+		t.Fatalf("expected 1 backup, got %d: %v", n, backups)
+	}
+
+	if s := readGzip(t, backups[0]); s != "3:04AM\n3:34AM\n" {
+		t.Errorf("unexpected backup content %q", s)
+	}
+
+	if s := readFile(t, name); s != "4:04AM\n4:05AM\n" {
+		t.Errorf("unexpected file content %q", s)
+	}
+}
+
+func TestFile_RotateAgeReopen(t *testing.T) {
+	var (
+		now  = time.Date(2025, 1, 2, 3, 4, 5, 0, time.Local)
+		name = filepath.Join(t.TempDir(), "gsocks5.log")
+	)
+
+	// the file was written before the restart more than max age ago
+	if err := os.WriteFile(name, []byte("old\n"), fileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(name, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	lf, err := Open(name, Rotation{MaxAge: time.Hour}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.now = clock(&now)
+
+	for _, line := range []string{"new\n", "next\n"} {
+		// the file age is not reset by reopening, the new file is started by the rotation
+		if err = lf.Reopen(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = lf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(30 * time.Minute)
+	}
+
+	if err = lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := lf.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(backups); n != 1 {
+		t.Fatalf("expected 1 backup, got %d: %v", n, backups)
+	}
+
+	if s := readGzip(t, backups[0]); s != "old\n" {
+		t.Errorf("unexpected backup content %q", s)
+	}
+
+	if s := readFile(t, name); s != "new\nnext\n" {
+		t.Errorf("unexpected file content %q", s)
+	}
+}
+
+func TestFile_Reopen(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		name  = filepath.Join(dir, "access.log")
+		moved = filepath.Join(dir, "access.log.1")
+	)
+
+	lf, err := Open(name, Rotation{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = lf.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// an external tool moves the file and sends a signal
+	if err = os.Rename(name, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lf.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = lf.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, moved); s != "before\n" {
+		t.Errorf("unexpected moved file content %q", s)
+	}
+
+	if s := readFile(t, name); s != "after\n" {
+		t.Errorf("unexpected file content %q", s)
+	}
+
+	if err = lf.Reopen(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected closed file error, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "not", "existing", "dir.log")
+	if _, err := Open(name, Rotation{}, nil); !errors.Is(err, ErrRotate) {
+		t.Errorf("expected ErrRotate, got %v", err)
+	}
+}
+
+func TestFile_CompressError(t *testing.T) {
+	var (
+		now    = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+		name   = filepath.Join(t.TempDir(), "access.log")
+		errs   = make(chan error, 1)
+		backup = name + "." + now.Format(backupTime)
+	)
+
+	lf, err := Open(name, Rotation{MaxSize: 10}, func(e error) { errs <- e })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.now = clock(&now)
+
+	// the compressed backup can not be created over the directory
+	if err = os.Mkdir(backup+backupExt, 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first\n", "second\n"} {
+		if _, err = lf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-errs:
+		if !errors.Is(err, ErrRotate) {
+			t.Errorf("expected ErrRotate, got %v", err)
+		}
+	default:
+		t.Error("compression error is not reported")
+	}
+
+	// the error is not written to the log file and the backup is kept uncompressed
+	if s := readFile(t, name); s != "second\n" {
+		t.Errorf("unexpected file content %q", s)
+	}
+
+	if s := readFile(t, backup); s != "first\n" {
+		t.Errorf("unexpected backup content %q", s)
+	}
+}
+
+func TestFile_RotateOpenError(t *testing.T) {
+	var (
+		now       = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+		name      = filepath.Join(t.TempDir(), "access.log")
+		errNoFile = errors.New("no file")
+	)
+
+	lf, err := Open(name, Rotation{MaxSize: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf.now = clock(&now)
+
+	if _, err = lf.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is renamed, but a new one can not be opened
+	lf.openFile = func(string, int, os.FileMode) (*os.File, error) { return nil, errNoFile }
+	for _, line := range []string{"second\n", "third\n"} {
+		if _, err = lf.Write([]byte(line)); !errors.Is(err, ErrRotate) || !errors.Is(err, errNoFile) {
+			t.Errorf("expected open error, got %v", err)
+		}
+	}
+
+	// the next write opens the file without a signal
+	lf.openFile = os.OpenFile
+	if _, err = lf.Write([]byte("fourth\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = lf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readFile(t, name); s != "fourth\n" {
+		t.Errorf("unexpected file content %q", s)
+	}
+
+	if s := readGzip(t, name+"."+now.Format(backupTime)+backupExt); s != "first\n" {
+		t.Errorf("unexpected backup content %q", s)
+	}
+
+	if _, err = lf.Write([]byte("closed\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected closed file error, got %v", err)
+	}
+}
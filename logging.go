@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/z0rr0/gsocks5/logfile"
+)
+
+// Log formats.
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// stdout is a log file name of the standard output.
+const stdout = "-"
+
+// logger is a common logger of the process, it is replaced by flags values after their parsing.
+var logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// logFormat checks the log format name.
+func logFormat(s string, format *string) error {
+	switch s {
+	case logText, logJSON:
+		*format = s
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", s, logText, logJSON)
+	}
+}
+
+// newLogger returns a logger of records with the level and higher ones in the format.
+// Debug records contain a source code position.
+func newLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level, AddSource: level <= slog.LevelDebug}
+
+	if format == logJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// openLog opens the log file with the rotation settings, "-" is the standard output without a file.
+// Errors of backups compression are reported by the common logger, which is replaced after the file is opened.
+func openLog(name string, rotation logfile.Rotation) (io.Writer, *logfile.File, error) {
+	if name == stdout {
+		return os.Stdout, nil, nil
+	}
+
+	f, err := logfile.Open(name, rotation, func(err error) {
+		logger.Error("failed to rotate log file", "file", name, "error", err)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, f, nil
+}
+
+// fatal logs the error and exits with non-zero code.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"time"
+
+	"github.com/z0rr0/gsocks5/dns"
+	"github.com/z0rr0/gsocks5/metrics"
+	"github.com/z0rr0/gsocks5/server"
+)
+
+// listenerMetrics are metrics of one listener for upstream connections, name lookups and users' traffic.
+type listenerMetrics struct {
+	name         string
+	dial         *metrics.Histogram
+	dialErrors   *metrics.Counter
+	lookup       *metrics.Histogram
+	lookupErrors *metrics.Counter
+	traffic      *metrics.CounterVec
+}
+
+// newListenerMetrics registers metrics of the listener.
+func newListenerMetrics(r *metrics.Registry, name string) *listenerMetrics {
+	return &listenerMetrics{
+		name: name,
+		dial: r.Histogram(
+			"gsocks5_dial_duration_seconds", "Duration of upstream connections dialing.",
+			metrics.DefaultBuckets, "listener",
+		).With(name),
+		dialErrors: r.Counter("gsocks5_dial_errors_total", "Failed upstream connections.", "listener").With(name),
+		lookup: r.Histogram(
+			"gsocks5_dns_lookup_duration_seconds", "Duration of DNS lookups.",
+			metrics.DefaultBuckets, "listener",
+		).With(name),
+		lookupErrors: r.Counter("gsocks5_dns_lookup_errors_total", "Failed DNS lookups.", "listener").With(name),
+		traffic: r.Counter(
+			"gsocks5_user_bytes_total", "Bytes received from (in) and sent to (out) destinations by users.",
+			"listener", "user", "direction",
+		),
+	}
+}
+
+// ObserveDial counts the upstream connection dialing.
+func (lm *listenerMetrics) ObserveDial(d time.Duration, err error) {
+	lm.dial.Observe(d.Seconds())
+	if err != nil {
+		lm.dialErrors.Inc()
+	}
+}
+
+// ObserveLookup counts the DNS lookup.
+func (lm *listenerMetrics) ObserveLookup(d time.Duration, err error) {
+	lm.lookup.Observe(d.Seconds())
+	if err != nil {
+		lm.lookupErrors.Inc()
+	}
+}
+
+// Count counts the user's traffic.
+func (lm *listenerMetrics) Count(user string, in, out int) error {
+	if in > 0 {
+		lm.traffic.With(lm.name, user, "in").Add(uint64(in))
+	}
+
+	if out > 0 {
+		lm.traffic.With(lm.name, user, "out").Add(uint64(out))
+	}
+
+	return nil
+}
+
+// registerServer registers metrics of the server counters, sessions and connection slots.
+func registerServer(r *metrics.Registry, name string, s *server.Server) {
+	c := &s.Counters
+
+	r.Gauge("gsocks5_sessions_active", "Active client sessions.", "listener").
+		Func(func() float64 { return float64(s.Sessions()) }, name)
+	r.Gauge("gsocks5_connection_slots", "Max concurrent connections.", "listener").
+		Func(func() float64 { _, capacity := s.Slots(); return float64(capacity) }, name)
+	r.Gauge("gsocks5_connection_slots_used", "Used concurrent connections slots.", "listener").
+		Func(func() float64 { used, _ := s.Slots(); return float64(used) }, name)
+
+	r.Counter("gsocks5_connections_accepted_total", "Accepted client connections.", "listener").
+		Func(c.Accepted.Load, name)
+	r.Counter("gsocks5_connections_overflow_total", "Connections limit overflow events.", "listener").
+		Func(c.Overflow.Load, name)
+	r.Counter("gsocks5_sessions_terminated_total", "Sessions closed after the drain period.", "listener").
+		Func(c.Terminated.Load, name)
+
+	rejected := r.Counter(
+		"gsocks5_connections_rejected_total", "Rejected client connections by reason.", "listener", "reason",
+	)
+	rejected.Func(c.RejectedIP.Load, name, "ip")
+	rejected.Func(c.RejectedPrefix.Load, name, "prefix")
+	rejected.Func(c.RejectedRate.Load, name, "rate")
+	rejected.Func(c.RejectedProxy.Load, name, "proxy")
+	rejected.Func(c.RejectedLimit.Load, name, "limit")
+
+	auth := r.Counter("gsocks5_auth_total", "Client authentications by result.", "listener", "result")
+	auth.Func(c.AuthSuccess.Load, name, "success")
+	auth.Func(c.AuthFailure.Load, name, "failure")
+}
+
+// registerBlocker registers metrics of the listener blocklists.
+func registerBlocker(r *metrics.Registry, name string, b *dns.Blocker) {
+	entries := r.Gauge("gsocks5_blocklist_entries", "Blocked domains by blocklist.", "listener", "blocklist")
+	blocked := r.Counter(
+		"gsocks5_blocked_lookups_total", "Blocked name lookups by blocklist.", "listener", "blocklist",
+	)
+
+	for list := range b.Stats() {
+		entries.Func(func() float64 { return float64(b.Stats()[list].Entries) }, name, list)
+		blocked.Func(func() uint64 { return b.Stats()[list].Blocked }, name, list)
+	}
+}
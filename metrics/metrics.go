@@ -0,0 +1,339 @@
+// Package metrics implements counters, gauges and histograms with Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is a content type of Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types.
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds for network latencies.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc increments the counter.
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Histogram counts observed values in buckets.
+type Histogram struct {
+	bounds []float64
+	counts []atomic.Uint64 // not cumulative counts of buckets, the last one is +Inf
+	sum    atomic.Uint64   // float64 bits
+}
+
+// newHistogram returns a histogram with sorted upper bounds of buckets.
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// Observe adds the value to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i, _ := slices.BinarySearch(h.bounds, v)
+	h.counts[i].Add(1)
+
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// series is a metric with label values, only one of its value fields is set.
+type series struct {
+	values    []string
+	counter   *Counter
+	histogram *Histogram
+	fn        func() float64
+}
+
+// family is a metric name with its series.
+type family struct {
+	sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	items   map[string]*series
+}
+
+// get returns a series by label values creating it if needed.
+func (f *family) get(values []string, create func() *series) *series {
+	if len(values) != len(f.labels) {
+		panic("metric " + f.name + ": expected " + strconv.Itoa(len(f.labels)) + " label values")
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.Lock()
+	defer f.Unlock()
+
+	if s, ok := f.items[key]; ok {
+		return s
+	}
+
+	s := create()
+	s.values = slices.Clone(values)
+	f.items[key] = s
+
+	return s
+}
+
+// sorted returns series ordered by label values.
+func (f *family) sorted() []*series {
+	f.Lock()
+	items := make([]*series, 0, len(f.items))
+	for _, s := range f.items {
+		items = append(items, s)
+	}
+	f.Unlock()
+
+	slices.SortFunc(items, func(a, b *series) int {
+		return slices.Compare(a.values, b.values)
+	})
+
+	return items
+}
+
+// CounterVec is a counter family with labels.
+type CounterVec struct {
+	f *family
+}
+
+// With returns a counter with the label values.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.f.get(values, func() *series { return &series{counter: &Counter{}} }).counter
+}
+
+// Func sets a function that returns the counter value with the label values.
+func (v *CounterVec) Func(fn func() uint64, values ...string) {
+	v.f.get(values, func() *series { return &series{fn: func() float64 { return float64(fn()) }} })
+}
+
+// GaugeVec is a gauge family with labels, its values are read by functions during exposition.
+type GaugeVec struct {
+	f *family
+}
+
+// Func sets a function that returns the gauge value with the label values.
+func (v *GaugeVec) Func(fn func() float64, values ...string) {
+	v.f.get(values, func() *series { return &series{fn: fn} })
+}
+
+// HistogramVec is a histogram family with labels.
+type HistogramVec struct {
+	f *family
+}
+
+// With returns a histogram with the label values.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.f.get(values, func() *series { return &series{histogram: newHistogram(v.f.buckets)} }).histogram
+}
+
+// Registry is a set of metric families.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// add registers a new family or returns the existing one with the same name.
+func (r *Registry) add(name, help, kind string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, f := range r.families {
+		if f.name == name {
+			if f.kind != kind || !slices.Equal(f.labels, labels) {
+				panic("metric " + name + " is already registered with another type or labels")
+			}
+			return f
+		}
+	}
+
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, items: make(map[string]*series)}
+	r.families = append(r.families, f)
+
+	return f
+}
+
+// Counter returns a counter family with the label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.add(name, help, typeCounter, nil, labels)}
+}
+
+// Gauge returns a gauge family with the label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.add(name, help, typeGauge, nil, labels)}
+}
+
+// Histogram returns a histogram family with upper bounds of buckets and the label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	return &HistogramVec{f: r.add(name, help, typeHistogram, buckets, labels)}
+}
+
+// WriteTo writes all metrics in Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.write(cw)
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	return cw.n, cw.err
+}
+
+// ServeHTTP writes all metrics as the response.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = r.WriteTo(w) // the client has gone
+}
+
+// write writes the family metadata and its series.
+func (f *family) write(w *countWriter) {
+	w.print("# HELP ", f.name, " ", escapeHelp(f.help), "\n")
+	w.print("# TYPE ", f.name, " ", f.kind, "\n")
+
+	for _, s := range f.sorted() {
+		labels := f.labelPairs(s.values)
+
+		switch {
+		case s.counter != nil:
+			w.sample(f.name, labels, "", "", float64(s.counter.value.Load()))
+		case s.histogram != nil:
+			f.writeHistogram(w, labels, s.histogram)
+		default:
+			w.sample(f.name, labels, "", "", s.fn())
+		}
+	}
+}
+
+// writeHistogram writes cumulative buckets, sum and count of the histogram.
+func (f *family) writeHistogram(w *countWriter, labels string, h *Histogram) {
+	var total uint64
+
+	for i, bound := range h.bounds {
+		total += h.counts[i].Load()
+		w.sample(f.name+"_bucket", labels, "le", formatFloat(bound), float64(total))
+	}
+
+	total += h.counts[len(h.bounds)].Load()
+	w.sample(f.name+"_bucket", labels, "le", "+Inf", float64(total))
+	w.sample(f.name+"_sum", labels, "", "", math.Float64frombits(h.sum.Load()))
+	w.sample(f.name+"_count", labels, "", "", float64(total))
+}
+
+// labelPairs returns formatted label pairs `name="value"` separated by commas.
+func (f *family) labelPairs(values []string) string {
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = f.labels[i] + `="` + escapeLabel(value) + `"`
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// countWriter is a writer that keeps the first error and a number of written bytes.
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+// print writes the strings if there was no error.
+func (cw *countWriter) print(values ...string) {
+	for _, value := range values {
+		if cw.err != nil {
+			return
+		}
+
+		n, err := cw.w.WriteString(value)
+		cw.n += int64(n)
+		cw.err = err
+	}
+}
+
+// sample writes a line with the metric name, labels, an optional extra label and the value.
+func (cw *countWriter) sample(name, labels, extraName, extraValue string, value float64) {
+	if extraName != "" {
+		extra := extraName + `="` + extraValue + `"`
+		if labels == "" {
+			labels = extra
+		} else {
+			labels += "," + extra
+		}
+	}
+
+	if labels == "" {
+		cw.print(name, " ", formatFloat(value), "\n")
+		return
+	}
+
+	cw.print(name, "{", labels, "} ", formatFloat(value), "\n")
+}
+
+// formatFloat formats the value by Prometheus rules.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// escapeHelp escapes backslashes and line feeds of the help text.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes backslashes, double quotes and line feeds of the label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	accepted := r.Counter("test_accepted_total", "Accepted connections.", "listener")
+	accepted.With("b").Add(2)
+	accepted.With("a").Inc()
+	accepted.With("a").Inc()
+
+	r.Counter("test_rejected_total", "Rejected\nconnections.", "listener", "reason").
+		Func(func() uint64 { return 7 }, "a", `ip "limit"`)
+	r.Gauge("test_sessions", "Active sessions.").Func(func() float64 { return 3 })
+
+	latency := r.Histogram("test_dial_seconds", "Dial latency.", []float64{1, 0.1}, "listener")
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		latency.With("a").Observe(v)
+	}
+
+	expected := `# HELP test_accepted_total Accepted connections.
+# TYPE test_accepted_total counter
+test_accepted_total{listener="a"} 2
+test_accepted_total{listener="b"} 2
+# HELP test_rejected_total Rejected\nconnections.
+# TYPE test_rejected_total counter
+test_rejected_total{listener="a",reason="ip \"limit\""} 7
+# HELP test_sessions Active sessions.
+# TYPE test_sessions gauge
+test_sessions 3
+# HELP test_dial_seconds Dial latency.
+# TYPE test_dial_seconds histogram
+test_dial_seconds_bucket{listener="a",le="0.1"} 2
+test_dial_seconds_bucket{listener="a",le="1"} 3
+test_dial_seconds_bucket{listener="a",le="+Inf"} 4
+test_dial_seconds_sum{listener="a"} 2.65
+test_dial_seconds_count{listener="a"} 4
+`
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(buf.Len()) {
+		t.Errorf("unexpected written bytes %d, buffer %d", n, buf.Len())
+	}
+
+	if s := buf.String(); s != expected {
+		t.Errorf("unexpected output:\n%s", s)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	if r.Counter("test_total", "Test.", "a").f != r.Counter("test_total", "Test.", "a").f {
+		t.Error("expected the same family")
+	}
+
+	panics := map[string]func(){
+		"type":   func() { r.Gauge("test_total", "Test.", "a") },
+		"labels": func() { r.Counter("test_total", "Test.", "b") },
+		"values": func() { r.Counter("test_total", "Test.", "a").With("x", "y") },
+	}
+
+	for name, fn := range panics {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+func TestHistogram_Observe(t *testing.T) {
+	var (
+		wg sync.WaitGroup
+		h  = newHistogram([]float64{1})
+	)
+
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				h.Observe(0.5)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := h.counts[0].Load(); n != 1000 {
+		t.Errorf("unexpected count %d", n)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Test.").With().Inc()
+
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := resp.Body.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), "test_total 1\n") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
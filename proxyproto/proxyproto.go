@@ -0,0 +1,347 @@
+// Package proxyproto implements PROXY protocol v1 and v2 headers of HAProxy and load balancers.
+// The header is sent by a proxy before client data to pass the original client address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxV1Length = 107 // max length of v1 header including CRLF
+
+	v2Version  = 0x20
+	v2Local    = 0x00
+	v2Proxy    = 0x01
+	v2HeadSize = 16 // signature, version and command, family and protocol, length
+
+	familyTCP4 = 0x11
+	familyTCP6 = 0x21
+
+	addrLengthIPv4 = 12 // source and destination addresses and ports
+	addrLengthIPv6 = 36
+)
+
+var (
+	// ErrHeader is returned when the PROXY protocol header is absent or invalid.
+	ErrHeader = errors.New("invalid PROXY protocol header")
+
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// Header is a PROXY protocol header.
+// Addresses are nil for health checks and unknown protocols, then the connection addresses are used.
+type Header struct {
+	Version     int
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+}
+
+// Read reads PROXY protocol v1 or v2 header from the reader.
+func Read(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, errors.Join(ErrHeader, err)
+	}
+
+	if bytes.Equal(prefix, v1Prefix) {
+		return readV1(r)
+	}
+
+	if prefix, err = r.Peek(len(v2Signature)); err != nil {
+		return nil, errors.Join(ErrHeader, err)
+	}
+
+	if bytes.Equal(prefix, v2Signature) {
+		return readV2(r)
+	}
+
+	return nil, errors.Join(ErrHeader, errors.New("unknown signature"))
+}
+
+// readV1 reads a text header "PROXY TCP4|TCP6|UNKNOWN SRC DST SRCPORT DSTPORT\r\n".
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.Join(ErrHeader, err)
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.Join(ErrHeader, errors.New("v1 header is not terminated"))
+	}
+
+	fields := strings.Split(text, " ")
+	header := &Header{Version: 1}
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Join(ErrHeader, fmt.Errorf("invalid v1 header %q", text))
+	}
+
+	source, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+
+	destination, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	header.Source, header.Destination = source, destination
+	return header, nil
+}
+
+// parseV1Addr returns TCP address of the protocol family.
+func parseV1Addr(family, ip, port string) (*net.TCPAddr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Is4() != (family == "TCP4") || addr.Zone() != "" {
+		return nil, errors.Join(ErrHeader, fmt.Errorf("invalid %s address %q", family, ip))
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, errors.Join(ErrHeader, fmt.Errorf("invalid port %q", port))
+	}
+
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// readV2 reads a binary header, type-length-value extensions are skipped.
+func readV2(r *bufio.Reader) (*Header, error) {
+	head := make([]byte, v2HeadSize)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, errors.Join(ErrHeader, err)
+	}
+
+	command, family := head[12], head[13]
+	if command&0xF0 != v2Version {
+		return nil, errors.Join(ErrHeader, fmt.Errorf("unsupported version 0x%x", command>>4))
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.Join(ErrHeader, err)
+	}
+
+	header := &Header{Version: 2}
+	switch command & 0x0F {
+	case v2Local:
+		return header, nil // health check of the proxy itself
+	case v2Proxy:
+	default:
+		return nil, errors.Join(ErrHeader, fmt.Errorf("unsupported command 0x%x", command&0x0F))
+	}
+
+	var size int
+	switch family {
+	case familyTCP4:
+		size = net.IPv4len
+	case familyTCP6:
+		size = net.IPv6len
+	default:
+		return header, nil // unsupported family, addresses are not used
+	}
+
+	if len(payload) < 2*size+4 {
+		return nil, errors.Join(ErrHeader, fmt.Errorf("short addresses block %d", len(payload)))
+	}
+
+	source, _ := netip.AddrFromSlice(payload[:size])
+	destination, _ := netip.AddrFromSlice(payload[size : 2*size])
+	ports := payload[2*size:]
+
+	header.Source = net.TCPAddrFromAddrPort(netip.AddrPortFrom(source, binary.BigEndian.Uint16(ports)))
+	header.Destination = net.TCPAddrFromAddrPort(netip.AddrPortFrom(destination, binary.BigEndian.Uint16(ports[2:])))
+
+	return header, nil
+}
+
+// Bytes returns the header in the format of its version, v2 is used for any version except 1.
+// The header without addresses has UNKNOWN protocol for v1 and LOCAL command for v2.
+func (h *Header) Bytes() []byte {
+	source, destination, ok := h.addrPorts()
+
+	if h.Version == 1 {
+		if !ok {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+
+		family := "TCP6"
+		if source.Addr().Is4() {
+			family = "TCP4"
+		}
+
+		return fmt.Appendf(
+			nil, "PROXY %s %s %s %d %d\r\n",
+			family, source.Addr(), destination.Addr(), source.Port(), destination.Port(),
+		)
+	}
+
+	b := append([]byte{}, v2Signature...)
+	if !ok {
+		return append(b, v2Version|v2Local, 0, 0, 0)
+	}
+
+	if source.Addr().Is4() {
+		b = append(b, v2Version|v2Proxy, familyTCP4, 0, addrLengthIPv4)
+	} else {
+		b = append(b, v2Version|v2Proxy, familyTCP6, 0, addrLengthIPv6)
+	}
+
+	b = append(b, source.Addr().AsSlice()...)
+	b = append(b, destination.Addr().AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, source.Port())
+
+	return binary.BigEndian.AppendUint16(b, destination.Port())
+}
+
+// addrPorts returns the header addresses of the same family,
+// IPv4 address is mapped to IPv6 if another one is IPv6.
+func (h *Header) addrPorts() (netip.AddrPort, netip.AddrPort, bool) {
+	if h.Source == nil || h.Destination == nil {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	source, destination := h.Source.AddrPort(), h.Destination.AddrPort()
+	if !source.Addr().IsValid() || !destination.Addr().IsValid() {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	s, d := source.Addr().Unmap(), destination.Addr().Unmap()
+	if s.Is4() != d.Is4() {
+		s, d = netip.AddrFrom16(s.As16()), netip.AddrFrom16(d.As16())
+	}
+
+	return netip.AddrPortFrom(s.WithZone(""), source.Port()), netip.AddrPortFrom(d.WithZone(""), destination.Port()), true
+}
+
+// Conn is a connection with addresses from the PROXY protocol header.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+// NewConn reads the header from the connection and returns its wrapper with the client addresses.
+// The connection deadline should be set to limit waiting of the header.
+func NewConn(c net.Conn) (*Conn, *Header, error) {
+	reader := bufio.NewReader(c)
+
+	header, err := Read(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pc := &Conn{Conn: c, reader: reader, remote: c.RemoteAddr(), local: c.LocalAddr()}
+	if header.Source != nil {
+		pc.remote, pc.local = header.Source, header.Destination
+	}
+
+	return pc, header, nil
+}
+
+// Read reads data after the header.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the header.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr returns the destination address from the header.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// CloseWrite shuts down the writing side of the connection if it is supported.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+
+	return nil
+}
+
+// Trusted is a list of networks which are allowed to send PROXY protocol headers.
+type Trusted []netip.Prefix
+
+// ParseTrusted parses comma-separated IP addresses and CIDR networks.
+func ParseTrusted(value string) (Trusted, error) {
+	var result Trusted
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted address %q: %w", item, err)
+			}
+
+			result = append(result, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted network %q: %w", item, err)
+		}
+
+		result = append(result, prefix.Masked())
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no trusted networks")
+	}
+
+	return result, nil
+}
+
+// Contains returns true if the address IP is in trusted networks.
+func (t Trusted) Contains(addr net.Addr) bool {
+	if len(t) == 0 {
+		return false
+	}
+
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+
+	ip := ap.Addr().Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,256 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// v2Header returns a binary header with the command, family and addresses block.
+func v2Header(command, family byte, addresses []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, v2Version|command, family, byte(len(addresses)>>8), byte(len(addresses)))
+	return append(b, addresses...)
+}
+
+func TestRead(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0x30, 0x39, 0x04, 0x38}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0, 80, 1, 187)
+	withTLV := append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0xFF)
+
+	testCases := []struct {
+		name        string
+		data        []byte
+		version     int
+		source      string
+		destination string
+		err         bool
+	}{
+		{
+			name:    "v1TCP4",
+			data:    []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 1080\r\n"),
+			version: 1, source: "192.0.2.1:12345", destination: "198.51.100.2:1080",
+		},
+		{
+			name:    "v1TCP6",
+			data:    []byte("PROXY TCP6 2001:db8::1 2001:db8::2 80 443\r\n"),
+			version: 1, source: "[2001:db8::1]:80", destination: "[2001:db8::2]:443",
+		},
+		{name: "v1Unknown", data: []byte("PROXY UNKNOWN\r\n"), version: 1},
+		{name: "v1Family", data: []byte("PROXY TCP4 2001:db8::1 192.0.2.1 80 443\r\n"), err: true},
+		{name: "v1Port", data: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 080 443\r\n"), err: true},
+		{name: "v1Fields", data: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 80\r\n"), err: true},
+		{name: "v1NoCRLF", data: []byte("PROXY TCP4 192.0.2.1 192.0.2.2 80 443\n"), err: true},
+		{name: "v1Long", data: []byte("PROXY " + strings.Repeat("A", maxV1Length)), err: true},
+		{
+			name:    "v2TCP4",
+			data:    v2Header(v2Proxy, familyTCP4, ipv4),
+			version: 2, source: "192.0.2.1:12345", destination: "198.51.100.2:1080",
+		},
+		{
+			name:    "v2TCP6",
+			data:    v2Header(v2Proxy, familyTCP6, ipv6),
+			version: 2, source: "[2001:db8::1]:80", destination: "[2001:db8::2]:443",
+		},
+		{
+			name:    "v2TLV",
+			data:    v2Header(v2Proxy, familyTCP4, withTLV),
+			version: 2, source: "192.0.2.1:12345", destination: "198.51.100.2:1080",
+		},
+		{name: "v2Local", data: v2Header(v2Local, 0, nil), version: 2},
+		{name: "v2Unspec", data: v2Header(v2Proxy, 0, nil), version: 2},
+		{name: "v2Short", data: v2Header(v2Proxy, familyTCP4, ipv4[:8]), err: true},
+		{name: "v2Command", data: v2Header(0x05, familyTCP4, ipv4), err: true},
+		{name: "v2Truncated", data: v2Header(v2Proxy, familyTCP4, ipv4)[:20], err: true},
+		{name: "socks", data: []byte{5, 1, 0}, err: true},
+		{name: "http", data: []byte("GET / HTTP/1.1\r\n\r\n"), err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header, err := Read(bufio.NewReader(bytes.NewReader(tc.data)))
+			if tc.err {
+				if !errors.Is(err, ErrHeader) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if header.Version != tc.version {
+				t.Errorf("unexpected version %d", header.Version)
+			}
+
+			if tc.source == "" {
+				if header.Source != nil || header.Destination != nil {
+					t.Errorf("unexpected addresses %v, %v", header.Source, header.Destination)
+				}
+				return
+			}
+
+			if s := header.Source.String(); s != tc.source {
+				t.Errorf("unexpected source %s", s)
+			}
+
+			if d := header.Destination.String(); d != tc.destination {
+				t.Errorf("unexpected destination %s", d)
+			}
+		})
+	}
+}
+
+func TestHeader_Bytes(t *testing.T) {
+	ipv4 := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 12345}
+	ipv6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+	dst4 := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 1080}
+
+	testCases := []struct {
+		name        string
+		header      Header
+		text        string
+		source      string
+		destination string
+	}{
+		{
+			name:   "v1TCP4",
+			header: Header{Version: 1, Source: ipv4, Destination: dst4},
+			text:   "PROXY TCP4 192.0.2.1 198.51.100.2 12345 1080\r\n",
+			source: "192.0.2.1:12345", destination: "198.51.100.2:1080",
+		},
+		{
+			name:   "v1Mixed",
+			header: Header{Version: 1, Source: ipv4, Destination: ipv6},
+			text:   "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 12345 443\r\n",
+			source: "192.0.2.1:12345", destination: "[2001:db8::2]:443",
+		},
+		{name: "v1Unknown", header: Header{Version: 1, Source: ipv4}, text: "PROXY UNKNOWN\r\n"},
+		{
+			name:   "v2TCP4",
+			header: Header{Version: 2, Source: ipv4, Destination: dst4},
+			source: "192.0.2.1:12345", destination: "198.51.100.2:1080",
+		},
+		{
+			name:   "v2TCP6",
+			header: Header{Version: 2, Source: ipv6, Destination: ipv4},
+			source: "[2001:db8::2]:443", destination: "192.0.2.1:12345",
+		},
+		{name: "v2Local", header: Header{Version: 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := tc.header.Bytes()
+			if tc.text != "" && string(data) != tc.text {
+				t.Errorf("unexpected header %q", data)
+			}
+
+			header, err := Read(bufio.NewReader(bytes.NewReader(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if header.Version != tc.header.Version {
+				t.Errorf("unexpected version %d", header.Version)
+			}
+
+			if tc.source == "" {
+				if header.Source != nil {
+					t.Errorf("unexpected source %v", header.Source)
+				}
+				return
+			}
+
+			if s := header.Source.String(); s != tc.source {
+				t.Errorf("unexpected source %s", s)
+			}
+
+			if d := header.Destination.String(); d != tc.destination {
+				t.Errorf("unexpected destination %s", d)
+			}
+		})
+	}
+}
+
+func TestNewConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	go func() {
+		_, _ = client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 1080\r\nhello"))
+	}()
+
+	c, header, err := NewConn(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if header.Version != 1 {
+		t.Errorf("unexpected version %d", header.Version)
+	}
+
+	if addr := c.RemoteAddr().String(); addr != "192.0.2.1:12345" {
+		t.Errorf("unexpected remote address %s", addr)
+	}
+
+	if addr := c.LocalAddr().String(); addr != "198.51.100.2:1080" {
+		t.Errorf("unexpected local address %s", addr)
+	}
+
+	// data after the header is not lost
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("unexpected data %q: %v", buf, err)
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	trusted, err := ParseTrusted("10.0.0.0/8, 192.0.2.1,2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		addr     string
+		expected bool
+	}{
+		{addr: "10.1.2.3:1080", expected: true},
+		{addr: "192.0.2.1:1080", expected: true},
+		{addr: "[::ffff:192.0.2.1]:1080", expected: true},
+		{addr: "192.0.2.2:1080"},
+		{addr: "[2001:db8::5]:1080", expected: true},
+		{addr: "[2001:db9::5]:1080"},
+	}
+
+	for _, tc := range testCases {
+		addr, e := net.ResolveTCPAddr("tcp", tc.addr)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		if result := trusted.Contains(addr); result != tc.expected {
+			t.Errorf("unexpected result for %s: %v", tc.addr, result)
+		}
+	}
+
+	if Trusted(nil).Contains(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}) {
+		t.Error("empty list contains address")
+	}
+
+	if (Trusted{}).Contains(&net.UnixAddr{Name: "/run/gsocks5.sock"}) {
+		t.Error("unix address is trusted")
+	}
+
+	for _, value := range []string{"", "bad", "10.0.0.0/33"} {
+		if _, err = ParseTrusted(value); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package quota
+
+import "os"
+
+// lockFile is not supported, the state file is shared only by processes of one upgrade on unix.
+func lockFile(*os.File) error {
+	return nil
+}
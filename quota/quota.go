@@ -0,0 +1,378 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+	"github.com/z0rr0/gsocks5/limit"
+)
+
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+var (
+	// ErrQuota is returned when the user's traffic quota is exhausted.
+	ErrQuota = errors.New("traffic quota is exhausted")
+	// ErrConfig is returned when the quotas file is invalid.
+	ErrConfig = errors.New("invalid quotas file")
+	// ErrState is returned when the state file can not be read or written.
+	ErrState = errors.New("invalid quotas state file")
+)
+
+// Limits are traffic quotas of a user in bytes (in and out together), zero value means no limit.
+type Limits struct {
+	Daily   uint64 `json:"daily,omitempty"`
+	Monthly uint64 `json:"monthly,omitempty"`
+	Total   uint64 `json:"total,omitempty"`
+}
+
+// Usage is a traffic of a user in bytes.
+// In is data received from destinations and Out is data sent to them.
+type Usage struct {
+	In       uint64 `json:"in"`
+	Out      uint64 `json:"out"`
+	Day      string `json:"day"`
+	DayIn    uint64 `json:"day_in"`
+	DayOut   uint64 `json:"day_out"`
+	Month    string `json:"month"`
+	MonthIn  uint64 `json:"month_in"`
+	MonthOut uint64 `json:"month_out"`
+}
+
+// rotate resets daily and monthly counters if their period is over.
+func (u *Usage) rotate(now time.Time) {
+	if day := now.Format(dayLayout); u.Day != day {
+		u.Day, u.DayIn, u.DayOut = day, 0, 0
+	}
+
+	if month := now.Format(monthLayout); u.Month != month {
+		u.Month, u.MonthIn, u.MonthOut = month, 0, 0
+	}
+}
+
+// since returns counters of the traffic after the previous usage of the same user, zero usage returns all traffic.
+// Both usages are rotated to the time, so the previous daily and monthly counters are reset if their period is over.
+func (u *Usage) since(p Usage, now time.Time) Usage {
+	u.rotate(now)
+	p.rotate(now)
+
+	return Usage{
+		In:       u.In - p.In,
+		Out:      u.Out - p.Out,
+		Day:      u.Day,
+		DayIn:    u.DayIn - p.DayIn,
+		DayOut:   u.DayOut - p.DayOut,
+		Month:    u.Month,
+		MonthIn:  u.MonthIn - p.MonthIn,
+		MonthOut: u.MonthOut - p.MonthOut,
+	}
+}
+
+// add adds the traffic to the counters, they are rotated to the traffic periods.
+func (u *Usage) add(d Usage, now time.Time) {
+	u.rotate(now)
+	u.In += d.In
+	u.Out += d.Out
+	u.DayIn += d.DayIn
+	u.DayOut += d.DayOut
+	u.MonthIn += d.MonthIn
+	u.MonthOut += d.MonthOut
+}
+
+// exceeded returns true if any of the limits is reached.
+func (u *Usage) exceeded(l Limits) bool {
+	return (l.Daily > 0 && u.DayIn+u.DayOut >= l.Daily) ||
+		(l.Monthly > 0 && u.MonthIn+u.MonthOut >= l.Monthly) ||
+		(l.Total > 0 && u.In+u.Out >= l.Total)
+}
+
+// state is a content of the state file.
+type state struct {
+	Users map[string]*Usage `json:"users"`
+}
+
+// Accountant counts users' traffic, checks quotas and persists counters to the state file.
+type Accountant struct {
+	sync.Mutex
+	stateFile string
+	limits    map[string]Limits
+	cut       bool // close active sessions when quota is exhausted
+	users     map[string]*Usage
+	saved     map[string]Usage // counters after the last load or save of the state file
+	saving    sync.Mutex       // serializes saves of this process, other processes are serialized by the lock file
+	logger    *slog.Logger
+}
+
+// New returns a new Accountant with counters from the state file and quotas from the file
+// with lines "user daily|monthly|total SIZE". Quotas file name can be empty.
+// If cut is true, active sessions fail when the user's quota is exhausted.
+func New(stateFile, quotasFile string, cut bool, logger *slog.Logger) (*Accountant, error) {
+	users, err := loadState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := loadLimits(quotasFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("traffic accounting", "users", len(users), "quotas", len(limits), "cut", cut)
+	return &Accountant{
+		stateFile: filepath.Clean(stateFile),
+		limits:    limits,
+		cut:       cut,
+		users:     users,
+		saved:     snapshot(users),
+		logger:    logger,
+	}, nil
+}
+
+// loadState reads users' counters from the state file, it is not an error if the file does not exist.
+func loadState(fileName string) (map[string]*Usage, error) {
+	data, err := os.ReadFile(filepath.Clean(fileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return make(map[string]*Usage), nil
+		}
+		return nil, errors.Join(ErrState, err)
+	}
+
+	var s state
+	if err = json.Unmarshal(data, &s); err != nil {
+		return nil, errors.Join(ErrState, fmt.Errorf("failed to parse %s: %w", fileName, err))
+	}
+
+	if s.Users == nil {
+		s.Users = make(map[string]*Usage)
+	}
+
+	return s.Users, nil
+}
+
+// snapshot returns a copy of users' counters.
+func snapshot(users map[string]*Usage) map[string]Usage {
+	s := make(map[string]Usage, len(users))
+	for user, u := range users {
+		s[user] = *u
+	}
+
+	return s
+}
+
+// loadLimits reads users' quotas from the file.
+func loadLimits(fileName string) (map[string]Limits, error) {
+	if fileName == "" {
+		return nil, nil
+	}
+
+	limits := make(map[string]Limits)
+	if err := lines.Read(fileName, parseLimits(limits)); err != nil {
+		return nil, errors.Join(ErrConfig, err)
+	}
+
+	return limits, nil
+}
+
+// parseLimits returns a parser of lines "user daily|monthly|total SIZE" which sets users' quotas.
+func parseLimits(limits map[string]Limits) func([]string) error {
+	return func(values []string) error {
+		if len(values) != 3 {
+			return fmt.Errorf("expected user, period and size, got %q", strings.Join(values, " "))
+		}
+
+		size, err := limit.ParseSize(values[2])
+		if err != nil {
+			return err
+		}
+
+		l := limits[values[0]]
+		switch values[1] {
+		case "daily":
+			l.Daily = uint64(size)
+		case "monthly":
+			l.Monthly = uint64(size)
+		case "total":
+			l.Total = uint64(size)
+		default:
+			return fmt.Errorf("unknown quota period %q", values[1])
+		}
+
+		limits[values[0]] = l
+		return nil
+	}
+}
+
+// Count adds transferred bytes to the user's counters.
+// It returns ErrQuota if the quota is exhausted and active sessions should be closed.
+// Anonymous traffic is not counted.
+func (a *Accountant) Count(user string, in, out int) error {
+	if user == "" {
+		return nil
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	u, ok := a.users[user]
+	if !ok {
+		u = &Usage{}
+		a.users[user] = u
+	}
+
+	u.rotate(time.Now())
+	u.In += uint64(in)
+	u.Out += uint64(out)
+	u.DayIn += uint64(in)
+	u.DayOut += uint64(out)
+	u.MonthIn += uint64(in)
+	u.MonthOut += uint64(out)
+
+	if a.cut && u.exceeded(a.limits[user]) {
+		return ErrQuota
+	}
+
+	return nil
+}
+
+// Exceeded returns true if the user's quota is exhausted.
+func (a *Accountant) Exceeded(user string) bool {
+	l, ok := a.limits[user]
+	if !ok {
+		return false
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	u, ok := a.users[user]
+	if !ok {
+		return false
+	}
+
+	u.rotate(time.Now())
+	return u.exceeded(l)
+}
+
+// Save adds users' traffic since the last save to the state file and loads counters of other processes from it.
+// The file can be shared with another process, for example the previous one during upgrade.
+func (a *Accountant) Save() error {
+	a.saving.Lock()
+	defer a.saving.Unlock()
+
+	lock, err := os.OpenFile(a.stateFile+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Join(ErrState, err)
+	}
+	defer func() { _ = lock.Close() }() // it releases the lock
+
+	if err = lockFile(lock); err != nil {
+		return errors.Join(ErrState, err)
+	}
+
+	users, err := loadState(a.stateFile)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	a.Lock()
+	counted := snapshot(a.users)
+	merge(users, a.users, a.saved, now)
+	a.Unlock()
+
+	data, err := json.MarshalIndent(state{Users: users}, "", "  ")
+	if err != nil {
+		return errors.Join(ErrState, err)
+	}
+
+	// write to a temporary file and rename it to not lose the previous state on failure
+	tmpFile := a.stateFile + ".tmp"
+	if err = os.WriteFile(tmpFile, data, 0600); err != nil {
+		return errors.Join(ErrState, err)
+	}
+
+	if err = os.Rename(tmpFile, a.stateFile); err != nil {
+		return errors.Join(ErrState, err)
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	// the file content is the new base, traffic counted during the saving is added to it for the next save
+	a.saved = snapshot(users)
+	merge(users, a.users, counted, now)
+	a.users = users
+
+	return nil
+}
+
+// merge adds users' traffic since the previous counters to the destination ones.
+func merge(dst, users map[string]*Usage, prev map[string]Usage, now time.Time) {
+	for user, u := range users {
+		d, ok := dst[user]
+		if !ok {
+			d = &Usage{}
+			dst[user] = d
+		}
+
+		d.add(u.since(prev[user], now), now)
+	}
+}
+
+// Run saves the state every interval until the context is done.
+func (a *Accountant) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				a.logger.Error("failed to save traffic state", "error", err)
+			}
+		}
+	}
+}
+
+// Rules returns a rule set that rejects requests of users with exhausted quotas
+// and checks others by the next rule set.
+func (a *Accountant) Rules(next socks5.RuleSet) socks5.RuleSet {
+	if next == nil {
+		next = socks5.PermitAll()
+	}
+	return &quotaRules{accountant: a, next: next}
+}
+
+// quotaRules is a rule set that rejects requests of users with exhausted quotas.
+type quotaRules struct {
+	accountant *Accountant
+	next       socks5.RuleSet
+}
+
+// Allow rejects the request if the user's quota is exhausted.
+func (qr *quotaRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	if req.AuthContext != nil {
+		if user := req.AuthContext.Payload["Username"]; qr.accountant.Exceeded(user) {
+			qr.accountant.logger.Info("traffic quota is exhausted", "user", user)
+			return ctx, false
+		}
+	}
+
+	return qr.next.Allow(ctx, req)
+}
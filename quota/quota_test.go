@@ -0,0 +1,256 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+var logger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
+
+func quotasFile(t *testing.T, content string) string {
+	fileName := filepath.Join(t.TempDir(), "quotas.txt")
+
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName
+}
+
+func TestAccountant(t *testing.T) {
+	var (
+		stateFile = filepath.Join(t.TempDir(), "state.json")
+		limits    = quotasFile(t, "# quotas\nalice daily 1K\nalice total 1M\nbob monthly 100\n")
+	)
+
+	a, err := New(stateFile, limits, true, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Count("alice", 500, 100); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if a.Exceeded("alice") {
+		t.Error("unexpected exhausted quota")
+	}
+
+	if err = a.Count("alice", 400, 100); !errors.Is(err, ErrQuota) {
+		t.Errorf("expected ErrQuota, got %v", err)
+	}
+
+	if !a.Exceeded("alice") {
+		t.Error("expected exhausted quota")
+	}
+
+	// no quota and anonymous users
+	if err = errors.Join(a.Count("eve", 1<<30, 0), a.Count("", 1<<30, 0)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if a.Exceeded("eve") || a.Exceeded("bob") {
+		t.Error("unexpected exhausted quota")
+	}
+
+	if err = a.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// counters are restored from the state file
+	a, err = New(stateFile, limits, false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u := a.users["alice"]; u.In != 900 || u.Out != 200 || u.DayIn != 900 || u.MonthOut != 200 {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+
+	if _, ok := a.users[""]; ok {
+		t.Error("unexpected anonymous usage")
+	}
+
+	// sessions are not cut, but new ones are rejected
+	if err = a.Count("alice", 1, 1); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !a.Exceeded("alice") {
+		t.Error("expected exhausted quota")
+	}
+}
+
+func TestUsage_Rotate(t *testing.T) {
+	var (
+		now = time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+		u   = &Usage{In: 10, Out: 10, DayIn: 10, DayOut: 10, MonthIn: 10, MonthOut: 10}
+	)
+
+	u.rotate(now)
+	if u.In != 10 || u.DayIn != 0 || u.MonthIn != 0 || u.Day != "2026-01-31" || u.Month != "2026-01" {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+
+	u.DayIn, u.MonthIn = 5, 5
+	u.rotate(now.Add(30 * time.Minute))
+	if u.DayIn != 5 || u.MonthIn != 5 {
+		t.Errorf("unexpected rotation: %+v", u)
+	}
+
+	u.rotate(now.Add(2 * time.Hour))
+	if u.DayIn != 0 || u.MonthIn != 0 || u.Month != "2026-02" {
+		t.Errorf("expected new month: %+v", u)
+	}
+
+	if !u.exceeded(Limits{Total: 20}) || u.exceeded(Limits{Daily: 1, Monthly: 1}) {
+		t.Errorf("unexpected exceeded result: %+v", u)
+	}
+}
+
+func TestNew(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	if _, err := New(stateFile, "/not/existing/file", false, logger); !errors.Is(err, ErrConfig) {
+		t.Errorf("expected ErrConfig, got %v", err)
+	}
+
+	if err := os.WriteFile(stateFile, []byte("bad"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(stateFile, "", false, logger); !errors.Is(err, ErrState) {
+		t.Errorf("expected ErrState, got %v", err)
+	}
+
+	for _, content := range []string{"alice 1K\n", "alice weekly 1K\n", "alice daily bad\n"} {
+		if err := lines.Scan(strings.NewReader(content), parseLimits(make(map[string]Limits))); err == nil {
+			t.Errorf("expected error for %q", content)
+		}
+	}
+}
+
+func TestAccountant_Rules(t *testing.T) {
+	a, err := New(filepath.Join(t.TempDir(), "state.json"), quotasFile(t, "alice total 10\n"), false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := a.Rules(nil)
+	req := &socks5.Request{
+		Command:     socks5.ConnectCommand,
+		AuthContext: &socks5.AuthContext{Payload: map[string]string{"Username": "alice"}},
+	}
+
+	if _, ok := rules.Allow(context.Background(), req); !ok {
+		t.Error("expected allowed request")
+	}
+
+	if err = a.Count("alice", 10, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := rules.Allow(context.Background(), req); ok {
+		t.Error("expected rejected request")
+	}
+}
+
+func TestAccountant_Run(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	a, err := New(stateFile, "", false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Count("alice", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		a.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if _, err = os.Stat(stateFile); err != nil {
+		t.Errorf("expected saved state: %v", err)
+	}
+}
+
+func TestAccountant_SaveShared(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	// the previous process keeps counting during upgrade, the new one loads its saved state
+	old, err := New(stateFile, "", false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = old.Count("alice", 10, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = old.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := New(stateFile, "", false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := []struct {
+		a    *Accountant
+		user string
+		in   int
+	}{
+		{a: old, user: "alice", in: 5},
+		{a: next, user: "alice", in: 3},
+		{a: next, user: "bob", in: 7},
+	}
+	for _, c := range counts {
+		if err = c.a.Count(c.user, c.in, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// repeated saves do not add the same traffic twice
+	for _, a := range []*Accountant{next, old, next, old} {
+		if err = a.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := New(stateFile, "", false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for user, in := range map[string]uint64{"alice": 18, "bob": 7} {
+		if u := result.users[user]; u == nil || u.In != in || u.DayIn != in || u.MonthIn != in {
+			t.Errorf("unexpected %s usage: %+v", user, u)
+		}
+	}
+
+	// counters of the other process are loaded by the save
+	if u := old.users["bob"]; u == nil || u.In != 7 {
+		t.Errorf("unexpected loaded usage: %+v", u)
+	}
+}
@@ -0,0 +1,55 @@
+package quota
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Report writes a table of users' traffic from the state file with their quotas.
+// Counters of the current period are shown, the quotas file name can be empty.
+func Report(w io.Writer, stateFile, quotasFile string) error {
+	users, err := loadState(stateFile)
+	if err != nil {
+		return err
+	}
+
+	limits, err := loadLimits(quotasFile)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(users))
+	for user := range users {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+
+	var (
+		now = time.Now()
+		tw  = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	)
+
+	fmt.Fprintln(tw, "USER\tDAY IN\tDAY OUT\tMONTH IN\tMONTH OUT\tTOTAL IN\tTOTAL OUT\tQUOTA")
+	for _, user := range names {
+		u := users[user]
+		u.rotate(now)
+
+		status := "-"
+		if l, ok := limits[user]; ok {
+			status = "ok"
+			if u.exceeded(l) {
+				status = "exhausted"
+			}
+		}
+
+		fmt.Fprintf(
+			tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			user, u.DayIn, u.DayOut, u.MonthIn, u.MonthOut, u.In, u.Out, status,
+		)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,50 @@
+package quota
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	a, err := New(stateFile, "", false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Count("bob", 100, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Count("alice", 1010, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = a.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err = Report(&b, stateFile, quotasFile(t, "alice daily 1K\nbob daily 1K\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if n := len(lines); n != 3 {
+		t.Fatalf("expected 3 lines, got %d: %q", n, lines)
+	}
+
+	if f := strings.Fields(lines[1]); f[0] != "alice" || f[1] != "1010" || f[len(f)-1] != "exhausted" {
+		t.Errorf("unexpected line %q", lines[1])
+	}
+
+	if f := strings.Fields(lines[2]); f[0] != "bob" || f[2] != "10" || f[len(f)-1] != "ok" {
+		t.Errorf("unexpected line %q", lines[2])
+	}
+
+	if err = Report(&b, stateFile, "/not/existing/file"); err == nil {
+		t.Error("expected error")
+	}
+}
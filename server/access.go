@@ -0,0 +1,45 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// NoReply is a reply code of sessions closed before the SOCKS reply, for example on a failed handshake.
+const NoReply = -1
+
+// Close reasons of sessions which are not closed by their timers or admin.
+const (
+	ReasonClosed  = "closed"  // the client or destination has closed the connection
+	ReasonTimeout = "timeout" // the handshake is not finished in time
+	ReasonError   = "error"   // the session has failed
+)
+
+// AccessRecord is a summary of the completed session.
+type AccessRecord struct {
+	SessionInfo
+	Reply    int32 // SOCKS reply code or NoReply
+	Duration time.Duration
+	Reason   string // session close reason
+}
+
+// AccessLogger writes records of completed sessions.
+type AccessLogger interface {
+	LogAccess(r *AccessRecord)
+}
+
+// closeReason returns a reason of the session close by its timers reason and the serving error.
+func closeReason(reason string, err error) string {
+	const timeoutError = "i/o timeout"
+
+	switch {
+	case reason != "":
+		return reason
+	case err == nil:
+		return ReasonClosed
+	case strings.HasSuffix(err.Error(), timeoutError):
+		return ReasonTimeout
+	default:
+		return ReasonError
+	}
+}
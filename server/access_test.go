@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// accessRecords is an access logger that sends records to the channel.
+type accessRecords chan *AccessRecord
+
+func (ar accessRecords) LogAccess(r *AccessRecord) {
+	ar <- r
+}
+
+func TestCloseReason(t *testing.T) {
+	testCases := []struct {
+		reason   string
+		err      error
+		expected string
+	}{
+		{expected: ReasonClosed},
+		{reason: conn.ReasonIdle, err: errors.New("use of closed network connection"), expected: conn.ReasonIdle},
+		{err: errors.New("read tcp 127.0.0.1:1080: i/o timeout"), expected: ReasonTimeout},
+		{err: errors.New("connection refused"), expected: ReasonError},
+	}
+
+	for i, tc := range testCases {
+		if reason := closeReason(tc.reason, tc.err); reason != tc.expected {
+			t.Errorf("case %d: unexpected reason %q, expected %q", i, reason, tc.expected)
+		}
+	}
+}
+
+func TestServer_AccessLog(t *testing.T) {
+	target := listenEcho(t)
+	cfg := &socks5.Config{
+		Logger:      socksLogger,
+		Credentials: socks5.StaticCredentials{"alice": "secret"},
+		Dial:        conn.Dial(&net.Dialer{}, 0, logger),
+	}
+
+	s, err := New(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := conn.NewTimeoutPolicy(conn.Timeouts{Handshake: timeout, Idle: timeout}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := make(accessRecords, 2)
+	params := &Params{
+		Addr:        "127.0.0.1:0",
+		Connections: 2,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Timeout:     timeout,
+		Timeouts:    policy,
+		AccessLog:   records,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	defer func() {
+		params.Sigint <- os.Interrupt
+		<-stopped
+	}()
+
+	auth := &proxy.Auth{User: "alice", Password: "secret"}
+	dialer, err := proxy.SOCKS5("tcp", params.listener.Addr().String(), auth, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = echo(c); err != nil {
+		t.Fatal(err)
+	}
+
+	client := c.LocalAddr().String()
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the echo server closes the upstream connection after the client end of stream
+	r := receiveRecord(t, records)
+	if r.User != "alice" || r.Client != client || r.Destination != target || r.IP != "127.0.0.1" {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	if r.Reply != 0 || r.Reason != ReasonClosed || r.Sent != 4 || r.Received != 4 || r.Duration <= 0 {
+		t.Errorf("unexpected record %+v", r)
+	}
+
+	// the handshake is not finished
+	raw, err := net.Dial("tcp", params.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = raw.Close() }()
+
+	if r = receiveRecord(t, records); r.Reply != NoReply || r.Reason != ReasonTimeout || r.User != "" {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+// receiveRecord returns the next access record or fails the test after the timeout.
+func receiveRecord(t *testing.T, records accessRecords) *AccessRecord {
+	select {
+	case r := <-records:
+		return r
+	case <-time.After(timeout * 4):
+		t.Fatal("access record is not received")
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+)
+
+// Group runs several servers with independent settings in one process.
+// Signals handling, readiness and systemd notifications are common for all servers.
+type Group struct {
+	items  []groupItem
+	logger *slog.Logger
+	// BeforeUpgrade is called before a new process is started by upgrade signal, it saves a state for the process.
+	BeforeUpgrade func()
+}
+
+// groupItem is a server of the group with its parameters.
+type groupItem struct {
+	s *Server
+	p *Params
+}
+
+// NewGroup returns an empty servers group.
+func NewGroup(logger *slog.Logger) *Group {
+	return &Group{logger: logger}
+}
+
+// Add adds the server to the group, its signals channels and OnReady are managed by the group.
+func (g *Group) Add(s *Server, p *Params) {
+	g.items = append(g.items, groupItem{s: s, p: p})
+}
+
+// Len returns a number of servers in the group.
+func (g *Group) Len() int {
+	return len(g.items)
+}
+
+// ListenAndServe starts all servers and waits for a signal to stop them.
+// If any server fails to start, others are stopped. The onReady function is called when all servers are ready.
+// Returned error joins errors of all servers.
+func (g *Group) ListenAndServe(sigint, sigupgrade <-chan os.Signal, onReady func()) error {
+	var (
+		ready sync.WaitGroup
+		errs  = make(chan error, len(g.items))
+	)
+
+	ready.Add(len(g.items))
+	for _, item := range g.items {
+		var once sync.Once
+		started := func() { once.Do(ready.Done) }
+
+		item.p.grouped = true
+		item.p.Sigint = make(chan os.Signal, 1)
+		item.p.OnReady = started
+
+		go func() {
+			errs <- item.s.ListenAndServe(item.p) // an error is sent before readiness on start failure
+			started()
+		}()
+	}
+
+	ready.Wait()
+	if len(errs) == 0 {
+		g.serve(sigint, sigupgrade, onReady)
+	} else {
+		g.logger.Error("failed to start servers, stopping", "failed", len(errs), "servers", len(g.items))
+	}
+
+	for _, item := range g.items {
+		item.p.Sigint <- os.Interrupt // stopped servers do not read it, but the channel is buffered
+	}
+
+	var err error
+	for range g.items {
+		err = errors.Join(err, <-errs)
+	}
+
+	return err
+}
+
+// serve reports readiness of the group and waits for a stop signal.
+func (g *Group) serve(sigint, sigupgrade <-chan os.Signal, onReady func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if onReady != nil {
+		onReady()
+	}
+	if err := notifyReady(); err != nil {
+		g.logger.Warn("failed to notify readiness", "error", err)
+	}
+	go watchdog(ctx, g.logger)
+
+	g.waitSignal(sigint, sigupgrade)
+	if err := notify(notifyStopping); err != nil {
+		g.logger.Warn("failed to notify stopping", "error", err)
+	}
+}
+
+// waitSignal waits for a signal to stop the group.
+// Upgrade signals start a new process with listeners of all servers, this one is stopped only if the new one is ready.
+func (g *Group) waitSignal(sigint, sigupgrade <-chan os.Signal) {
+	for {
+		select {
+		case sig := <-sigint:
+			g.logger.Info("taken signal", "signal", sig.String())
+			return
+		case sig := <-sigupgrade:
+			g.logger.Info("taken signal, upgrading", "signal", sig.String())
+
+			pid, err := g.upgrade()
+			if err != nil {
+				g.logger.Error("upgrade is canceled", "error", err)
+				continue
+			}
+
+			g.logger.Info("new process is ready, draining", "pid", pid)
+			return
+		}
+	}
+}
+
+// upgrade prepares the state and starts a new process with listeners of all servers.
+func (g *Group) upgrade() (int, error) {
+	if g.BeforeUpgrade != nil {
+		g.BeforeUpgrade()
+	}
+
+	return upgrade(g.listeners(), g.logger)
+}
+
+// listeners returns listeners of all servers in the order of adding.
+func (g *Group) listeners() []net.Listener {
+	listeners := make([]net.Listener, len(g.items))
+	for i, item := range g.items {
+		listeners[i] = item.p.listener
+	}
+
+	return listeners
+}
+
+// TakeListener returns a pre-opened listener for the address and the remaining listeners.
+// If there is no listener with the same address, but the only listener is expected and the only one is pre-opened,
+// it's returned anyway. The result listener is nil if there is no suitable one.
+func TakeListener(listeners []net.Listener, addr string, only bool) (net.Listener, []net.Listener) {
+	if only && len(listeners) == 1 {
+		return listeners[0], nil
+	}
+
+	for i, listener := range listeners {
+		if sameAddr(listener.Addr(), addr) {
+			rest := make([]net.Listener, 0, len(listeners)-1)
+			rest = append(rest, listeners[:i]...)
+			return listener, append(rest, listeners[i+1:]...)
+		}
+	}
+
+	return nil, listeners
+}
+
+// sameAddr returns true if the listener address is the same as the TCP or unix socket address.
+// All unspecified IP addresses are considered equal.
+func sameAddr(listenerAddr net.Addr, addr string) bool {
+	if path, ok := unixPath(addr); ok {
+		ua, isUnix := listenerAddr.(*net.UnixAddr)
+		return isUnix && ua.Name == path
+	}
+
+	a, ok := listenerAddr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	b, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || a.Port != b.Port {
+		return false
+	}
+
+	if b.IP == nil || b.IP.IsUnspecified() {
+		return a.IP == nil || a.IP.IsUnspecified()
+	}
+
+	return a.IP.Equal(b.IP)
+}
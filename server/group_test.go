@@ -0,0 +1,174 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// groupServer returns a new server and its parameters for a group.
+func groupServer(t *testing.T, addr string) (*Server, *Params) {
+	s, err := New(&socks5.Config{Logger: socksLogger, Dial: conn.Dial(&net.Dialer{}, 0, logger)}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, &Params{Addr: addr, Connections: 2, Done: make(chan struct{}), Timeout: timeout, Drain: timeout}
+}
+
+func TestGroup_ListenAndServe(t *testing.T) {
+	target := listenEcho(t)
+	group := NewGroup(logger)
+
+	items := make([]*Params, 2)
+	for i := range items {
+		s, params := groupServer(t, "127.0.0.1:0")
+		group.Add(s, params)
+		items[i] = params
+	}
+
+	if n := group.Len(); n != len(items) {
+		t.Fatalf("unexpected group size %d", n)
+	}
+
+	var (
+		sigint  = make(chan os.Signal)
+		ready   = make(chan struct{})
+		stopped = make(chan error)
+	)
+	go func() {
+		stopped <- group.ListenAndServe(sigint, nil, func() { close(ready) })
+	}()
+
+	<-ready
+	for _, params := range items {
+		dialer, err := proxy.SOCKS5("tcp", params.listener.Addr().String(), nil, proxy.Direct)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := dialer.Dial("tcp", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = c.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 4)
+		if _, err = io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Errorf("unexpected response %q: %v", buf, err)
+		}
+
+		if err = c.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	sigint <- os.Interrupt
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, params := range items {
+		if _, err := net.Dial("tcp", params.listener.Addr().String()); err == nil {
+			t.Error("expected closed listener")
+		}
+	}
+}
+
+func TestGroup_StartFailure(t *testing.T) {
+	group := NewGroup(logger)
+
+	s, started := groupServer(t, "127.0.0.1:0")
+	group.Add(s, started)
+
+	s, failed := groupServer(t, "127.0.0.1:-1")
+	group.Add(s, failed)
+
+	var called bool
+	if err := group.ListenAndServe(make(chan os.Signal), nil, func() { called = true }); err == nil {
+		t.Error("expected error")
+	}
+
+	if called {
+		t.Error("unexpected readiness")
+	}
+
+	if started.listener != nil {
+		if _, err := net.Dial("tcp", started.listener.Addr().String()); err == nil {
+			t.Error("expected closed listener")
+		}
+	}
+}
+
+func TestTakeListener(t *testing.T) {
+	listeners := make([]net.Listener, 2)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listeners[i] = l
+		defer func() { _ = l.Close() }()
+	}
+
+	addr := listeners[1].Addr().String()
+	listener, rest := TakeListener(listeners, addr, false)
+	if listener != listeners[1] || len(rest) != 1 || rest[0] != listeners[0] {
+		t.Errorf("unexpected result %v, %v", listener, rest)
+	}
+
+	if listener, rest = TakeListener(rest, addr, false); listener != nil || len(rest) != 1 {
+		t.Errorf("unexpected result %v, %v", listener, rest)
+	}
+
+	// the only listener is used regardless of the address
+	if listener, rest = TakeListener(rest, addr, true); listener != listeners[0] || len(rest) != 0 {
+		t.Errorf("unexpected result %v, %v", listener, rest)
+	}
+}
+
+func TestSameAddr(t *testing.T) {
+	var (
+		loopback = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}
+		wildcard = &net.TCPAddr{IP: net.IPv6unspecified, Port: 1080}
+	)
+
+	testCases := []struct {
+		name     string
+		listener *net.TCPAddr
+		addr     string
+		expected bool
+	}{
+		{name: "equal", listener: loopback, addr: "127.0.0.1:1080", expected: true},
+		{name: "port", listener: loopback, addr: "127.0.0.1:1081"},
+		{name: "ip", listener: loopback, addr: "127.0.0.2:1080"},
+		{name: "unspecified", listener: wildcard, addr: ":1080", expected: true},
+		{name: "unspecified4", listener: wildcard, addr: "0.0.0.0:1080", expected: true},
+		{name: "specific", listener: wildcard, addr: "127.0.0.1:1080"},
+		{name: "invalid", listener: wildcard, addr: "bad"},
+		{name: "tcpUnix", listener: wildcard, addr: "unix:/run/gsocks5.sock"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := sameAddr(tc.listener, tc.addr); result != tc.expected {
+				t.Errorf("unexpected result %v", result)
+			}
+		})
+	}
+
+	socket := &net.UnixAddr{Name: "/run/gsocks5.sock", Net: "unix"}
+	if !sameAddr(socket, "unix:/run/gsocks5.sock") || sameAddr(socket, "unix:/run/other.sock") {
+		t.Error("unexpected unix socket address result")
+	}
+}
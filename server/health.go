@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ErrHealth is returned when the server health check fails.
+var ErrHealth = errors.New("health check failed")
+
+// Check connects to the server address and performs SOCKS5 handshake, "unix:" prefix is used for unix sockets.
+// Without the user only "no authentication" method is offered, and the server rejection of it is a valid reply.
+// With the user the password authentication should succeed.
+func Check(ctx context.Context, addr, user, password string) error {
+	network := "tcp"
+	if path, ok := unixPath(addr); ok {
+		network, addr = "unix", path
+	}
+
+	var d net.Dialer
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return errors.Join(ErrHealth, err)
+	}
+
+	if err = handshake(ctx, c, user, password); err != nil {
+		return errors.Join(ErrHealth, err, c.Close())
+	}
+
+	if err = c.Close(); err != nil {
+		return errors.Join(ErrHealth, err)
+	}
+
+	return nil
+}
+
+// handshake sends the greeting and credentials of the user to the server and checks its replies.
+func handshake(ctx context.Context, c net.Conn, user, password string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	method := byte(authNone)
+	if user != "" {
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("too long credentials")
+		}
+		method = authPassword
+	}
+
+	if _, err := c.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		return fmt.Errorf("failed to read method reply: %w", err)
+	}
+
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected version 0x%x", reply[0])
+	}
+
+	switch {
+	case reply[1] == method && method == authNone:
+		return nil
+	case reply[1] == authNoAccept && method == authNone:
+		return nil // the server works, but it requires authentication
+	case reply[1] != method:
+		return fmt.Errorf("unexpected method 0x%x", reply[1])
+	}
+
+	request := append([]byte{passwordVersion, byte(len(user))}, user...)
+	request = append(append(request, byte(len(password))), password...)
+	if _, err := c.Write(request); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(c, reply); err != nil {
+		return fmt.Errorf("failed to read authentication reply: %w", err)
+	}
+
+	if reply[1] != 0 {
+		return fmt.Errorf("authentication failed with status 0x%x", reply[1])
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/armon/go-socks5"
+)
+
+func TestCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout*4)
+	defer cancel()
+
+	// no authentication
+	_, addr := serve(t, OverflowBlock, 0)
+	if err := Check(ctx, addr, "", ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// password authentication on unix socket
+	path := serveUnix(t, nil)
+	testCases := []struct {
+		name     string
+		user     string
+		password string
+		err      bool
+	}{
+		{name: "noCredentials"},
+		{name: "valid", user: "user", password: "password"},
+		{name: "invalid", user: "user", password: "wrong", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Check(ctx, UnixPrefix+path, tc.user, tc.password)
+			if tc.err {
+				if !errors.Is(err, ErrHealth) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	if err := Check(ctx, UnixPrefix+socketPath(t), "", ""); !errors.Is(err, ErrHealth) {
+		t.Errorf("unexpected error for absent socket: %v", err)
+	}
+}
+
+func TestServer_Accepting(t *testing.T) {
+	s, err := New(&socks5.Config{Logger: socksLogger}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Accepting() {
+		t.Error("not started server is accepting")
+	}
+
+	params := &Params{
+		Addr:        "127.0.0.1:0",
+		Connections: 1,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Timeout:     timeout,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	if !s.Accepting() {
+		t.Error("started server is not accepting")
+	}
+
+	params.Sigint <- os.Interrupt
+	<-stopped
+
+	if s.Accepting() {
+		t.Error("stopped server is accepting")
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+)
+
+const (
+	prefixIPv4 = 24 // IPv4 network prefix length to limit connections
+	prefixIPv6 = 64 // IPv6 network prefix length to limit connections
+)
+
+// limitReason is a reason of rejected connection by ipLimiter.
+type limitReason string
+
+const (
+	limitIP     limitReason = "ip"
+	limitPrefix limitReason = "prefix"
+)
+
+// ipLimiter limits concurrent connections per client IP address and its network prefix.
+// Zero limit disables the check.
+type ipLimiter struct {
+	sync.Mutex
+	perIP     uint32
+	perPrefix uint32
+	ips       map[netip.Addr]uint32
+	prefixes  map[netip.Prefix]uint32
+}
+
+// newIPLimiter returns a new ipLimiter or nil if both limits are disabled.
+func newIPLimiter(perIP, perPrefix uint32) *ipLimiter {
+	if perIP == 0 && perPrefix == 0 {
+		return nil
+	}
+
+	return &ipLimiter{
+		perIP:     perIP,
+		perPrefix: perPrefix,
+		ips:       make(map[netip.Addr]uint32),
+		prefixes:  make(map[netip.Prefix]uint32),
+	}
+}
+
+// clientKeys returns client IP address and its network prefix.
+func clientKeys(addr net.Addr) (netip.Addr, netip.Prefix, bool) {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, netip.Prefix{}, false
+	}
+
+	ip := ap.Addr().Unmap()
+	bits := prefixIPv6
+	if ip.Is4() {
+		bits = prefixIPv4
+	}
+
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.Addr{}, netip.Prefix{}, false
+	}
+
+	return ip, prefix, true
+}
+
+// acquire takes a connection slot for the client address.
+// It returns a reason if a limit is reached, addresses without IP are not limited.
+func (l *ipLimiter) acquire(addr net.Addr) (limitReason, bool) {
+	ip, prefix, ok := clientKeys(addr)
+	if !ok {
+		return "", true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.perIP > 0 && l.ips[ip] >= l.perIP {
+		return limitIP, false
+	}
+
+	if l.perPrefix > 0 && l.prefixes[prefix] >= l.perPrefix {
+		return limitPrefix, false
+	}
+
+	l.ips[ip]++
+	l.prefixes[prefix]++
+	return "", true
+}
+
+// release frees a connection slot of the client address.
+func (l *ipLimiter) release(addr net.Addr) {
+	ip, prefix, ok := clientKeys(addr)
+	if !ok {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.ips[ip]--; l.ips[ip] == 0 {
+		delete(l.ips, ip)
+	}
+
+	if l.prefixes[prefix]--; l.prefixes[prefix] == 0 {
+		delete(l.prefixes, prefix)
+	}
+}
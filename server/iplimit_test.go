@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+)
+
+func TestIPLimiter(t *testing.T) {
+	if l := newIPLimiter(0, 0); l != nil {
+		t.Fatal("expected nil limiter")
+	}
+
+	var (
+		l       = newIPLimiter(2, 3)
+		client1 = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 50000}
+		client2 = &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 50000}
+		client3 = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000}
+		mapped  = &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1"), Port: 50001}
+		unix    = &net.UnixAddr{Name: "@", Net: "unix"}
+	)
+
+	testCases := []struct {
+		addr   net.Addr
+		reason limitReason
+	}{
+		{addr: client1},
+		{addr: mapped},
+		{addr: client1, reason: limitIP},
+		{addr: client2},
+		{addr: client2, reason: limitPrefix},
+		{addr: client3},
+		{addr: unix},
+		{addr: unix},
+	}
+
+	for i, tc := range testCases {
+		reason, ok := l.acquire(tc.addr)
+		if ok != (tc.reason == "") || reason != tc.reason {
+			t.Errorf("case %d: acquire(%s) = %q, %v", i, tc.addr, reason, ok)
+		}
+	}
+
+	l.release(client1)
+	if _, ok := l.acquire(client2); !ok {
+		t.Error("expected free slot after release")
+	}
+
+	for _, addr := range []net.Addr{client1, client2, client2, client3, unix} {
+		l.release(addr)
+	}
+
+	if n, m := len(l.ips), len(l.prefixes); n != 0 || m != 0 {
+		t.Errorf("expected empty limiter, got %d ips and %d prefixes", n, m)
+	}
+}
+
+func TestServer_IPConnections(t *testing.T) {
+	s, err := New(&socks5.Config{Logger: socksLogger}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:          "127.0.0.1:0",
+		Connections:   10,
+		IPConnections: 1,
+		Done:          make(chan struct{}),
+		Sigint:        make(chan os.Signal),
+		Timeout:       timeout,
+	}
+
+	go func() {
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	defer func() { params.Sigint <- os.Interrupt }()
+
+	addr := params.listener.Addr().String()
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = second.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second connection is closed by the server
+	if _, err = second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	if n := s.Counters.RejectedIP.Load(); n != 1 {
+		t.Errorf("expected 1 rejected connection, got %d", n)
+	}
+
+	if err = errors.Join(first.Close(), second.Close()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Overflow is a policy for new connections when the connections limit is reached.
+type Overflow string
+
+// Connections limit overflow policies.
+const (
+	OverflowBlock  Overflow = "block"  // stop accepting, clients wait in the listen backlog
+	OverflowReject Overflow = "reject" // accept and reply "general failure" immediately
+	OverflowQueue  Overflow = "queue"  // accept and wait for a free slot during the queue timeout, reject if queue is full
+)
+
+const (
+	socks5Version   = 0x05
+	authNone        = 0x00
+	authPassword    = 0x02
+	authNoAccept    = 0xff
+	replyFailure    = 0x01
+	addrTypeIPv4    = 0x01
+	addrTypeFQDN    = 0x03
+	addrTypeIPv6    = 0x04
+	passwordVersion = 0x01 // username/password authentication version
+)
+
+// ErrOverflow is returned when the overflow policy is unknown.
+var ErrOverflow = errors.New("unknown overflow policy")
+
+// ParseOverflow checks that the value is a known overflow policy.
+func ParseOverflow(value string) (Overflow, error) {
+	switch o := Overflow(value); o {
+	case OverflowBlock, OverflowReject, OverflowQueue:
+		return o, nil
+	default:
+		return "", errors.Join(ErrOverflow, fmt.Errorf("invalid policy %q", value))
+	}
+}
+
+// acquire takes a connections slot by the overflow policy and counts overflow events.
+// It returns false if the connection should be rejected.
+func (s *Server) acquire(p *Params, semaphore chan<- struct{}) bool {
+	select {
+	case semaphore <- struct{}{}:
+		return true
+	default:
+	}
+
+	total := s.Counters.Overflow.Add(1)
+	s.logger.Debug("connections limit is reached", "policy", p.Overflow, "total_overflow", total)
+
+	switch p.Overflow {
+	case OverflowReject:
+		return false
+	case OverflowQueue:
+		if p.queue != nil {
+			// every queued connection holds a file descriptor and a goroutine, so their number is limited
+			select {
+			case p.queue <- struct{}{}:
+				defer func() { <-p.queue }()
+			default:
+				s.logger.Debug("connections queue is full", "size", cap(p.queue))
+				return false
+			}
+		}
+
+		timer := time.NewTimer(p.QueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case semaphore <- struct{}{}:
+			return true
+		case <-timer.C:
+			return false
+		}
+	default:
+		semaphore <- struct{}{}
+		return true
+	}
+}
+
+// reject replies "general failure" to the client request and closes the connection.
+func (s *Server) reject(p *Params, conn net.Conn) {
+	client := conn.RemoteAddr()
+	total := s.Counters.RejectedLimit.Add(1)
+	s.logger.Info("rejected connection: connections limit is reached", "client", client.String(), "total_rejected", total)
+
+	if err := rejectRequest(conn, p.Timeout); err != nil {
+		s.logger.Debug("failed to reply to rejected connection", "client", client.String(), "error", err)
+	}
+
+	if err := conn.Close(); err != nil {
+		s.logger.Debug("failed to close rejected connection", "client", client.String(), "error", err)
+	}
+
+	if p.ipLimit != nil {
+		p.ipLimit.release(client)
+	}
+}
+
+// rejectRequest completes SOCKS5 handshake with the client and replies "general failure" to its request.
+// The deadline is always set, it is fallbackTimeout if the handshake timeout is zero.
+// Credentials are not checked, because the request is not served anyway.
+func rejectRequest(conn net.Conn, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(boundedTimeout(timeout))); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+
+	method, err := readMethods(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err = conn.Write([]byte{socks5Version, method}); err != nil {
+		return err
+	}
+
+	switch method {
+	case authNoAccept:
+		return nil
+	case authPassword:
+		if err = skipCredentials(r); err != nil {
+			return err
+		}
+
+		if _, err = conn.Write([]byte{passwordVersion, 0x00}); err != nil {
+			return err
+		}
+	}
+
+	if err = skipRequest(r); err != nil {
+		return err
+	}
+
+	_, err = conn.Write([]byte{socks5Version, replyFailure, 0x00, addrTypeIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// readMethods reads the client greeting and returns a selected authentication method.
+func readMethods(r io.Reader) (byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	if header[0] != socks5Version {
+		return 0, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return 0, err
+	}
+
+	for _, m := range []byte{authNone, authPassword} {
+		if bytes.IndexByte(methods, m) >= 0 {
+			return m, nil
+		}
+	}
+
+	return authNoAccept, nil
+}
+
+// skipCredentials reads username/password authentication request.
+func skipCredentials(r *bufio.Reader) error {
+	// version, username length, username, password length, password
+	if _, err := r.Discard(1); err != nil {
+		return err
+	}
+
+	for range 2 {
+		n, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if _, err = r.Discard(int(n)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// skipRequest reads the client request with its destination address.
+func skipRequest(r *bufio.Reader) error {
+	header := make([]byte, 4) // version, command, reserved, address type
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+
+	var size int
+	switch header[3] {
+	case addrTypeIPv4:
+		size = net.IPv4len
+	case addrTypeIPv6:
+		size = net.IPv6len
+	case addrTypeFQDN:
+		n, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		size = int(n)
+	default:
+		return fmt.Errorf("unknown address type %d", header[3])
+	}
+
+	_, err := r.Discard(size + 2) // address and port
+	return err
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/net/proxy"
+)
+
+// serve starts the server with one connection slot and returns its address.
+func serve(t *testing.T, overflow Overflow, queueTimeout time.Duration) (*Server, string) {
+	s, err := New(&socks5.Config{Logger: socksLogger}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:         "127.0.0.1:0",
+		Connections:  1,
+		Overflow:     overflow,
+		QueueTimeout: queueTimeout,
+		Done:         make(chan struct{}),
+		Sigint:       make(chan os.Signal),
+		Timeout:      timeout,
+	}
+
+	return s, serveParams(t, s, params)
+}
+
+// serveParams starts the server with the parameters and returns its address.
+func serveParams(t *testing.T, s *Server, params *Params) string {
+	go func() {
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	t.Cleanup(func() { params.Sigint <- os.Interrupt })
+
+	return params.listener.Addr().String()
+}
+
+func TestParseOverflow(t *testing.T) {
+	for _, value := range []string{"block", "reject", "queue"} {
+		if o, err := ParseOverflow(value); err != nil || string(o) != value {
+			t.Errorf("unexpected result for %q: %v, %v", value, o, err)
+		}
+	}
+
+	if _, err := ParseOverflow("drop"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestServer_Overflow(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if e := target.Close(); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	go func() {
+		for {
+			c, e := target.Accept()
+			if e != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+
+	testCases := []struct {
+		name         string
+		overflow     Overflow
+		queueTimeout time.Duration
+		rejected     bool
+	}{
+		{name: "reject", overflow: OverflowReject, rejected: true},
+		{name: "queueTimeout", overflow: OverflowQueue, queueTimeout: timeout / 5, rejected: true},
+		{name: "queue", overflow: OverflowQueue, queueTimeout: timeout * 4},
+		{name: "block", overflow: OverflowBlock},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			s, addr := serve(t, tc.overflow, tc.queueTimeout)
+
+			// the only slot is busy until the handshake timeout
+			busy, e := net.Dial("tcp", addr)
+			if e != nil {
+				t.Fatal(e)
+			}
+			defer func() { _ = busy.Close() }()
+
+			// the greeting reply means that the connection is served
+			if _, e = busy.Write([]byte{socks5Version, 1, authNone}); e != nil {
+				t.Fatal(e)
+			}
+			if _, e = io.ReadFull(busy, make([]byte, 2)); e != nil {
+				t.Fatal(e)
+			}
+
+			dialer, e := proxy.SOCKS5("tcp", addr, &proxy.Auth{User: "user", Password: "secret"}, proxy.Direct)
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			c, e := dialer.Dial("tcp", target.Addr().String())
+			if tc.rejected {
+				if e == nil || !strings.Contains(e.Error(), "general SOCKS server failure") {
+					t.Errorf("expected general failure, got %v", e)
+				}
+			} else {
+				if e != nil {
+					t.Fatalf("unexpected error: %v", e)
+				}
+				_ = c.Close()
+			}
+
+			if n := s.Counters.RejectedLimit.Load(); (n == 1) != tc.rejected {
+				t.Errorf("unexpected rejected connections number %d", n)
+			}
+
+			// blocking policy counts pauses of accept loop, so it can be more than one
+			if n := s.Counters.Overflow.Load(); n == 0 || (n > 1 && tc.overflow != OverflowBlock) {
+				t.Errorf("unexpected overflow events number %d", n)
+			}
+		})
+	}
+}
+
+func TestServer_QueueSize(t *testing.T) {
+	s, err := New(&socks5.Config{Logger: socksLogger}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serveParams(t, s, &Params{
+		Addr:         "127.0.0.1:0",
+		Connections:  1,
+		Overflow:     OverflowQueue,
+		QueueTimeout: time.Minute,
+		QueueSize:    1,
+		Done:         make(chan struct{}),
+		Sigint:       make(chan os.Signal),
+		Timeout:      timeout,
+	})
+
+	// the first connection takes the only slot, the second one waits in the queue
+	for range 2 {
+		c, e := net.Dial("tcp", addr)
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer func() { _ = c.Close() }()
+	}
+
+	for s.Counters.Overflow.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", addr, nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the queue is full, so the connection is rejected without waiting for the queue timeout
+	start := time.Now()
+	_, err = dialer.Dial("tcp", "127.0.0.1:1")
+	if err == nil || !strings.Contains(err.Error(), "general SOCKS server failure") {
+		t.Errorf("expected general failure, got %v", err)
+	}
+
+	if d := time.Since(start); d > timeout {
+		t.Errorf("rejection took %v", d)
+	}
+
+	if n := s.Counters.RejectedLimit.Load(); n != 1 {
+		t.Errorf("unexpected rejected connections number %d", n)
+	}
+}
+
+func TestRejectRequest(t *testing.T) {
+	defer func(d time.Duration) { fallbackTimeout = d }(fallbackTimeout)
+	fallbackTimeout = timeout / 5
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+
+	// a silent client does not block the rejection without the handshake timeout
+	done := make(chan error, 1)
+	go func() { done <- rejectRequest(server, 0) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected timeout error")
+		}
+	case <-time.After(timeout):
+		t.Error("request is not rejected")
+	}
+}
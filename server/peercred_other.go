@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "net"
+
+// peerUID is not supported, peers are authenticated by other methods.
+func peerUID(net.Conn) (uint32, bool) {
+	return 0, false
+}
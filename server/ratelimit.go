@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/z0rr0/gsocks5/limit"
+)
+
+const (
+	rateIdle   = time.Minute          // period to forget idle clients of rateLimiter
+	backoffMin = 5 * time.Millisecond // first delay after a temporary accept error
+	backoffMax = time.Second          // max delay after temporary accept errors
+)
+
+const (
+	limitRate   limitReason = "rate"    // global accept rate limit
+	limitRateIP limitReason = "ip rate" // per-IP accept rate limit
+)
+
+// clientBucket is a client's accept rate bucket with the time of its last connection.
+type clientBucket struct {
+	bucket *limit.Bucket
+	last   time.Time
+}
+
+// rateLimiter limits the rate of new connections globally and per client IP address.
+// Zero rate disables the check.
+type rateLimiter struct {
+	sync.Mutex
+	global  *limit.Bucket
+	perIP   int64
+	clients map[netip.Addr]*clientBucket
+	pruned  time.Time
+}
+
+// newRateLimiter returns a new rateLimiter or nil if both rates are disabled.
+// Rates are new connections per second, they are also burst sizes.
+func newRateLimiter(global, perIP uint32) *rateLimiter {
+	if global == 0 && perIP == 0 {
+		return nil
+	}
+
+	l := &rateLimiter{perIP: int64(perIP), clients: make(map[netip.Addr]*clientBucket), pruned: time.Now()}
+	if global > 0 {
+		l.global = limit.NewBucket(int64(global), 0)
+	}
+
+	return l
+}
+
+// allow takes a token for a new connection from the client address.
+// It returns a reason if a rate is exceeded, addresses without IP are limited only by the global rate.
+func (l *rateLimiter) allow(addr net.Addr) (limitReason, bool) {
+	if ip, _, ok := clientKeys(addr); ok && l.perIP > 0 {
+		if !l.client(ip, time.Now()).Allow() {
+			return limitRateIP, false
+		}
+	}
+
+	if l.global != nil && !l.global.Allow() {
+		return limitRate, false
+	}
+
+	return "", true
+}
+
+// client returns the bucket of the client IP address and forgets idle clients.
+func (l *rateLimiter) client(ip netip.Addr, now time.Time) *limit.Bucket {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.pruned) > rateIdle {
+		for key, c := range l.clients {
+			if now.Sub(c.last) > rateIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.pruned = now
+	}
+
+	c, ok := l.clients[ip]
+	if !ok {
+		c = &clientBucket{bucket: limit.NewBucket(l.perIP, 0)}
+		l.clients[ip] = c
+	}
+
+	c.last = now
+	return c.bucket
+}
+
+// isTemporary returns true if the accept error is caused by lack of resources and can be retried later.
+func isTemporary(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the next delay after a temporary accept error.
+func backoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return backoffMin
+	}
+
+	return min(delay*2, backoffMax)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if l := newRateLimiter(0, 0); l != nil {
+		t.Fatal("expected nil limiter")
+	}
+
+	var (
+		l       = newRateLimiter(3, 2)
+		client1 = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 50000}
+		client2 = &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 50000}
+		unix    = &net.UnixAddr{Name: "@", Net: "unix"}
+	)
+
+	testCases := []struct {
+		addr   net.Addr
+		reason limitReason
+	}{
+		{addr: client1},
+		{addr: client1},
+		{addr: client1, reason: limitRateIP},
+		{addr: client2},
+		{addr: unix, reason: limitRate},
+		{addr: client2, reason: limitRate},
+	}
+
+	for i, tc := range testCases {
+		reason, ok := l.allow(tc.addr)
+		if ok != (tc.reason == "") || reason != tc.reason {
+			t.Errorf("case %d: allow(%s) = %q, %v", i, tc.addr, reason, ok)
+		}
+	}
+
+	// idle clients are forgotten
+	now := time.Now().Add(2 * rateIdle)
+	l.client(netip.MustParseAddr("192.0.2.3"), now)
+
+	if n := len(l.clients); n != 1 {
+		t.Errorf("expected 1 client after pruning, got %d", n)
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected bool
+	}{
+		{err: &net.OpError{Op: "accept", Err: os.NewSyscallError("accept", syscall.EMFILE)}, expected: true},
+		{err: fmt.Errorf("failed: %w", syscall.ENFILE), expected: true},
+		{err: net.ErrClosed},
+		{err: errors.New("unknown")},
+	}
+
+	for i, tc := range testCases {
+		if r := isTemporary(tc.err); r != tc.expected {
+			t.Errorf("case %d: expected %v for %v", i, tc.expected, tc.err)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var delay time.Duration
+
+	for _, expected := range []time.Duration{backoffMin, 2 * backoffMin, 4 * backoffMin} {
+		if delay = backoff(delay); delay != expected {
+			t.Errorf("expected %v, got %v", expected, delay)
+		}
+	}
+
+	if delay = backoff(backoffMax); delay != backoffMax {
+		t.Errorf("expected max delay %v, got %v", backoffMax, delay)
+	}
+}
+
+func TestServer_AcceptRate(t *testing.T) {
+	s, err := New(&socks5.Config{Logger: socksLogger}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:         "127.0.0.1:0",
+		Connections:  10,
+		AcceptRateIP: 1,
+		Done:         make(chan struct{}),
+		Sigint:       make(chan os.Signal),
+		Timeout:      timeout,
+	}
+
+	go func() {
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	defer func() { params.Sigint <- os.Interrupt }()
+
+	addr := params.listener.Addr().String()
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Close() }()
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Close() }()
+
+	if err = second.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second connection exceeds the rate and is closed by the server
+	if _, err = second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	if n := s.Counters.RejectedRate.Load(); n != 1 {
+		t.Errorf("expected 1 rejected connection, got %d", n)
+	}
+}
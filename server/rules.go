@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// requestRules is a rule set that passes the request metadata to the dialer by the context.
+type requestRules struct {
+	next     socks5.RuleSet
+	sessions *sessions
+}
+
+// Allow adds the request metadata to the context and checks the request by the next rule set.
+func (rr *requestRules) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	r := &conn.Request{FQDN: req.DestAddr.FQDN}
+
+	if req.AuthContext != nil {
+		r.User = req.AuthContext.Payload["Username"]
+
+		if s := rr.sessions.get(req.AuthContext.Payload[sessionKey]); s != nil {
+			r.Session = s.watch
+			s.setDestination(destination(req.DestAddr), resolved(req.DestAddr))
+		}
+	}
+
+	if req.RemoteAddr != nil {
+		r.Client = net.JoinHostPort(req.RemoteAddr.IP.String(), strconv.Itoa(req.RemoteAddr.Port))
+	}
+
+	return rr.next.Allow(conn.WithRequest(ctx, r), req)
+}
+
+// destination returns the requested address "host:port", the host is a domain name if it is requested.
+func destination(addr *socks5.AddrSpec) string {
+	host := addr.FQDN
+	if host == "" {
+		host = addr.IP.String()
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// resolved returns the destination IP address or an empty string if it is not resolved.
+func resolved(addr *socks5.AddrSpec) string {
+	if addr.IP == nil {
+		return ""
+	}
+
+	return addr.IP.String()
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+func TestRequestRules_Allow(t *testing.T) {
+	rules := &requestRules{next: socks5.PermitAll()}
+	req := &socks5.Request{
+		Command:     socks5.ConnectCommand,
+		AuthContext: &socks5.AuthContext{Payload: map[string]string{"Username": "alice"}},
+		RemoteAddr:  &socks5.AddrSpec{IP: net.ParseIP("::1"), Port: 50000},
+		DestAddr:    &socks5.AddrSpec{FQDN: "github.com", IP: net.ParseIP("10.0.0.1"), Port: 443},
+	}
+
+	ctx, ok := rules.Allow(context.Background(), req)
+	if !ok {
+		t.Fatal("expected allowed request")
+	}
+
+	r := conn.RequestFrom(ctx)
+	if r.User != "alice" || r.Client != "[::1]:50000" || r.FQDN != "github.com" {
+		t.Errorf("unexpected request metadata: %+v", r)
+	}
+
+	if r = conn.RequestFrom(context.Background()); r.User != "" {
+		t.Errorf("expected empty request metadata: %+v", r)
+	}
+}
+
+func TestDestination(t *testing.T) {
+	testCases := []struct {
+		addr     socks5.AddrSpec
+		expected string
+	}{
+		{addr: socks5.AddrSpec{FQDN: "github.com", IP: net.ParseIP("10.0.0.1"), Port: 443}, expected: "github.com:443"},
+		{addr: socks5.AddrSpec{IP: net.ParseIP("10.0.0.1"), Port: 80}, expected: "10.0.0.1:80"},
+		{addr: socks5.AddrSpec{IP: net.ParseIP("2001:db8::1"), Port: 80}, expected: "[2001:db8::1]:80"},
+	}
+
+	for _, tc := range testCases {
+		if d := destination(&tc.addr); d != tc.expected {
+			t.Errorf("unexpected destination %q, expected %q", d, tc.expected)
+		}
+	}
+}
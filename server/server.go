@@ -4,37 +4,113 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/conn"
+	"github.com/z0rr0/gsocks5/proxyproto"
+)
+
+var (
+	// errLimited is returned when the connection is rejected by limits or checks, it is already logged and closed.
+	errLimited = errors.New("connection limit is reached")
+	// fallbackTimeout limits reading of PROXY protocol headers and rejected requests if the handshake timeout
+	// is not set, so a silent client can not hold its goroutine and the listener stop forever.
+	fallbackTimeout = 30 * time.Second
 )
 
+// boundedTimeout returns the handshake timeout or fallbackTimeout if it is not set.
+func boundedTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+
+	return fallbackTimeout
+}
+
+// Counters are server events counters.
+type Counters struct {
+	Accepted       atomic.Uint64 // admitted connections which are passed to handlers
+	RejectedIP     atomic.Uint64 // rejected by per-IP connections limit
+	RejectedPrefix atomic.Uint64 // rejected by per-prefix connections limit
+	RejectedRate   atomic.Uint64 // rejected by global or per-IP accept rate limits
+	RejectedProxy  atomic.Uint64 // rejected by absent or invalid PROXY protocol header
+	RejectedLimit  atomic.Uint64 // rejected by reject or queue policies when the connections limit is reached
+	AuthSuccess    atomic.Uint64 // successful client authentications
+	AuthFailure    atomic.Uint64 // failed client authentications
+	Terminated     atomic.Uint64 // sessions closed after the drain period
+	Overflow       atomic.Uint64 // connections limit is reached, for block policy it counts accept pauses
+}
+
 // Server is a socks5 server struct.
 type Server struct {
-	S        *socks5.Server
-	logInfo  *log.Logger
-	logDebug *log.Logger
+	S         *socks5.Server
+	Counters  Counters
+	sessions  *sessions
+	slots     atomic.Pointer[chan struct{}] // connections semaphore of the started server
+	accepting atomic.Bool                   // the listener is accepting connections, it is false during drain
+	logger    *slog.Logger
 }
 
 // Params is a start parameters for the server.
 type Params struct {
-	Addr        string
-	Connections uint32
-	Done        chan struct{} // only for testing
-	Sigint      chan os.Signal
-	Timeout     time.Duration
-	setReady    sync.Once
-	wg          sync.WaitGroup
-	listener    net.Listener
-}
-
-// Ready closes Done channel if it is not closed yet.
-func (p *Params) Ready() {
+	Addr              string
+	Connections       uint32
+	IPConnections     uint32             // concurrent connections per client IP, zero means no limit
+	PrefixConnections uint32             // concurrent connections per client /24 IPv4 or /64 IPv6 network
+	AcceptRate        uint32             // new connections per second, zero means no limit
+	AcceptRateIP      uint32             // new connections per second from one client IP
+	Overflow          Overflow           // connections limit overflow policy, block by default
+	QueueTimeout      time.Duration      // max waiting time of queued connections for OverflowQueue policy
+	QueueSize         uint32             // max number of queued connections for OverflowQueue policy, zero means no limit
+	Drain             time.Duration      // max waiting time of active sessions on shutdown, zero means no limit
+	Listener          net.Listener       // pre-opened listener, Addr is not used to listen if it is set
+	ProxyTrusted      proxyproto.Trusted // sources of PROXY protocol headers, other clients can not send them
+	SocketMode        os.FileMode        // unix socket file mode, zero keeps the default one
+	SocketOwner       string             // unix socket file owner "user[:group]", empty keeps the current one
+	Done              chan struct{}      // only for testing
+	Sigint            chan os.Signal
+	OnReady           func()              // called once when the server starts accepting connections
+	grouped           bool                // signals and systemd notifications are handled by Group
+	Timeout           time.Duration       // handshake timeout since accept
+	Timeouts          *conn.TimeoutPolicy // global and users' session timeouts, nil means no timers
+	AccessLog         AccessLogger        // records of completed sessions, nil disables them
+	setReady          sync.Once
+	wg                sync.WaitGroup
+	listener          net.Listener
+	ipLimit           *ipLimiter
+	rateLimit         *rateLimiter
+	queue             chan struct{} // slots of queued connections, nil means no limit
+}
+
+// Ready closes Done channel, calls OnReady and notifies systemd if it is not done yet.
+// It is called when the server starts accepting connections.
+func (p *Params) Ready() error {
+	var err error
+
+	p.setReady.Do(func() {
+		if p.Done != nil {
+			close(p.Done)
+		}
+		if p.OnReady != nil {
+			p.OnReady()
+		}
+		if !p.grouped {
+			err = notifyReady()
+		}
+	})
+
+	return err
+}
+
+// release closes Done channel without OnReady call if the server failed to start.
+func (p *Params) release() {
 	p.setReady.Do(func() {
 		if p.Done != nil {
 			close(p.Done)
@@ -43,19 +119,50 @@ func (p *Params) Ready() {
 }
 
 // New creates a new socks5 server.
-func New(cfg *socks5.Config, logInfo, logDebug *log.Logger) (*Server, error) {
+func New(cfg *socks5.Config, logger *slog.Logger) (*Server, error) {
+	if cfg.Rules == nil {
+		cfg.Rules = socks5.PermitAll()
+	}
+	s := &Server{sessions: newSessions(), logger: logger}
+	cfg.Rules = &requestRules{next: cfg.Rules, sessions: s.sessions}
+	cfg.AuthMethods = authMethods(cfg, &s.Counters)
+
 	server, err := socks5.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create socks5 server: %w", err)
 	}
-	return &Server{S: server, logInfo: logInfo, logDebug: logDebug}, nil
+
+	s.S = server
+	return s, nil
+}
+
+// Sessions returns a number of active sessions.
+func (s *Server) Sessions() int {
+	return s.sessions.count()
+}
+
+// Accepting returns true if the server is started and accepts new connections.
+// It is false before the start and after a shutdown or upgrade signal.
+func (s *Server) Accepting() bool {
+	return s.accepting.Load()
+}
+
+// Slots returns numbers of used and all connection slots, they are zero until the server starts.
+// Block overflow policy reserves a slot for the next connection before its accept.
+func (s *Server) Slots() (int, int) {
+	semaphore := s.slots.Load()
+	if semaphore == nil {
+		return 0, 0
+	}
+
+	return len(*semaphore), cap(*semaphore)
 }
 
 // ListenAndServe starts the socks5 server.
 func (s *Server) ListenAndServe(p *Params) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer func() {
-		p.Ready()
+		p.release()
 		cancel()
 	}()
 
@@ -65,40 +172,116 @@ func (s *Server) ListenAndServe(p *Params) error {
 		return err
 	}
 
-	s.logDebug.Printf("listener started on %s", p.Addr)
-	p.Ready()
-	go s.start(p, connections, semaphore)
+	s.logger.Debug("listener started", "addr", p.Addr)
+	s.accepting.Store(true)
+	if err = p.Ready(); err != nil {
+		s.logger.Warn("failed to notify readiness", "error", err)
+	}
 
-	return s.waitClose(p, done)
+	if !p.grouped {
+		go watchdog(ctx, s.logger)
+	}
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		s.start(p, connections, semaphore)
+	}()
+
+	return s.waitClose(p, done, handled)
 }
 
 // listen starts goroutine to accept incoming connections and sends them to a returned channel.
 func (s *Server) listen(ctx context.Context, p *Params, done chan<- struct{}) (<-chan net.Conn, <-chan struct{}, error) {
-	var lc net.ListenConfig
+	listener := p.Listener
+	if listener == nil {
+		var (
+			lc  net.ListenConfig
+			err error
+		)
 
-	listener, err := lc.Listen(ctx, "tcp", p.Addr)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to listen on %s: %w", p.Addr, err)
+		if path, ok := unixPath(p.Addr); ok {
+			listener, err = listenUnix(ctx, p, path)
+		} else {
+			listener, err = lc.Listen(ctx, "tcp", p.Addr)
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to listen on %s: %w", p.Addr, err)
+		}
 	}
 
 	p.listener = listener // to close it later
+	p.ipLimit = newIPLimiter(p.IPConnections, p.PrefixConnections)
+	p.rateLimit = newRateLimiter(p.AcceptRate, p.AcceptRateIP)
+	p.queue = nil
+	if p.QueueSize > 0 {
+		p.queue = make(chan struct{}, p.QueueSize)
+	}
 	connections := make(chan net.Conn)
 	semaphore := make(chan struct{}, p.Connections)
+	s.slots.Store(&semaphore)
 
 	go func() {
+		var (
+			pending  sync.WaitGroup // connections waiting for PROXY header, a slot or rejection
+			blocking = p.Overflow == "" || p.Overflow == OverflowBlock
+			delay    time.Duration // backoff after temporary accept errors
+		)
+
 		for {
-			semaphore <- struct{}{} // limit connections, Server.handle will release it
-			if conn, e := s.accept(listener, p); e != nil {
+			if blocking {
+				s.acquire(p, semaphore) // limit connections, Server.handle will release it
+			}
+
+			raw, e := s.accept(listener)
+			if e != nil {
 				if errors.Is(e, net.ErrClosed) {
 					break
 				}
-				s.logInfo.Printf("failed to accept connection [%T]: %v", e, e)
-			} else {
+				if blocking {
+					<-semaphore // the connection slot was not used
+				}
+				s.logger.Error("failed to accept connection", "error", e)
+				if isTemporary(e) {
+					delay = backoff(delay)
+					s.logger.Debug("accept backoff", "delay", delay)
+					time.Sleep(delay)
+				}
+				continue
+			}
+			delay = 0
+
+			if p.ProxyTrusted.Contains(raw.RemoteAddr()) {
+				// a balancer can delay PROXY protocol header, so it is read without blocking of the accept loop
+				pending.Add(1)
+				go func() {
+					defer pending.Done()
+					if conn, ok := s.admitted(p, raw, blocking, semaphore); ok {
+						s.enqueue(p, conn, blocking, semaphore, connections)
+					}
+				}()
+				continue
+			}
+
+			conn, ok := s.admitted(p, raw, blocking, semaphore)
+			if !ok {
+				continue
+			}
+
+			if blocking {
 				connections <- conn
+				continue
 			}
+
+			pending.Add(1)
+			go func() {
+				defer pending.Done()
+				s.enqueue(p, conn, blocking, semaphore, connections)
+			}()
 		}
 
-		s.logDebug.Printf("listener stopped")
+		pending.Wait()
+		s.logger.Debug("listener stopped")
 		close(connections) // finish workers
 		close(semaphore)   // no new incoming connections
 		close(done)
@@ -107,79 +290,301 @@ func (s *Server) listen(ctx context.Context, p *Params, done chan<- struct{}) (<
 	return connections, semaphore, nil
 }
 
+// admitted returns the connection if it is admitted, otherwise the unused connection slot is released.
+func (s *Server) admitted(p *Params, raw net.Conn, blocking bool, semaphore <-chan struct{}) (net.Conn, bool) {
+	conn, err := s.admit(p, raw)
+	if err == nil {
+		return conn, true
+	}
+
+	if blocking {
+		<-semaphore // the connection slot was not used
+	}
+	if !errors.Is(err, errLimited) {
+		s.logger.Error("failed to accept connection", "error", err)
+	}
+
+	return nil, false
+}
+
+// enqueue sends the admitted connection to workers.
+// A slot of blocking policy is already acquired, otherwise the connection waits for it or is rejected.
+func (s *Server) enqueue(
+	p *Params, conn net.Conn, blocking bool, semaphore chan struct{}, connections chan<- net.Conn,
+) {
+	if blocking {
+		connections <- conn
+		return
+	}
+
+	if !s.acquire(p, semaphore) {
+		s.reject(p, conn)
+		return
+	}
+
+	if p.Timeout > 0 {
+		// the connection could wait in the queue, so its handshake deadline is renewed
+		if err := conn.SetReadDeadline(time.Now().Add(p.Timeout)); err != nil {
+			s.logger.Debug(
+				"failed to set read deadline for queued connection", "client", conn.RemoteAddr().String(), "error", err,
+			)
+		}
+	}
+	connections <- conn
+}
+
 // accept accepts a new connection.
-func (s *Server) accept(listener net.Listener, p *Params) (net.Conn, error) {
+func (s *Server) accept(listener net.Listener) (net.Conn, error) {
 	conn, err := listener.Accept()
 	if err != nil {
 		return nil, fmt.Errorf("failed to accept connection: %w", err)
 	}
 
+	return conn, nil
+}
+
+// admit reads PROXY protocol header of trusted sources and checks client's limits.
+// The connection is closed if it is not admitted.
+func (s *Server) admit(p *Params, conn net.Conn) (net.Conn, error) {
+	var err error
+
 	if p.Timeout > 0 {
 		if err = conn.SetReadDeadline(time.Now().Add(p.Timeout)); err != nil {
-			return nil, fmt.Errorf("failed to set read deadline for connection: %w", err)
+			return nil, errors.Join(fmt.Errorf("failed to set read deadline for connection: %w", err), conn.Close())
 		}
 	}
 
-	s.logDebug.Printf("accepted connection from %s with timeout %v", conn.RemoteAddr().String(), p.Timeout)
+	if conn, err = s.proxyHeader(p, conn); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkRate(p, conn); err != nil {
+		return nil, err
+	}
+
+	if err = s.checkLimits(p, conn); err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug("admitted connection", "client", conn.RemoteAddr().String(), "timeout", p.Timeout)
 	return conn, nil
 }
 
+// proxyHeader returns the connection with the client address from PROXY protocol header of trusted sources.
+func (s *Server) proxyHeader(p *Params, conn net.Conn) (net.Conn, error) {
+	if !p.ProxyTrusted.Contains(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	if p.Timeout <= 0 {
+		// the handshake is not limited, but the header is always read with a deadline
+		if err := conn.SetReadDeadline(time.Now().Add(fallbackTimeout)); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to set read deadline for connection: %w", err), conn.Close())
+		}
+	}
+
+	pc, header, err := proxyproto.NewConn(conn)
+	if err != nil {
+		total := s.Counters.RejectedProxy.Add(1)
+		s.logger.Info("rejected connection", "client", conn.RemoteAddr().String(), "error", err, "total_rejected", total)
+
+		if closeErr := conn.Close(); closeErr != nil {
+			s.logger.Debug("failed to close rejected connection", "client", conn.RemoteAddr().String(), "error", closeErr)
+		}
+		return nil, errLimited
+	}
+
+	s.logger.Debug(
+		"PROXY protocol header",
+		"version", header.Version, "proxy", conn.RemoteAddr().String(), "client", pc.RemoteAddr().String(),
+	)
+	if p.Timeout <= 0 {
+		if err = conn.SetReadDeadline(time.Time{}); err != nil {
+			return nil, errors.Join(fmt.Errorf("failed to reset read deadline for connection: %w", err), conn.Close())
+		}
+	}
+
+	return pc, nil
+}
+
+// checkRate closes the connection if the global or client's accept rate is exceeded.
+// Rejections are logged only in debug mode to not flood the log during connections floods.
+func (s *Server) checkRate(p *Params, conn net.Conn) error {
+	if p.rateLimit == nil {
+		return nil
+	}
+
+	reason, ok := p.rateLimit.allow(conn.RemoteAddr())
+	if ok {
+		return nil
+	}
+
+	total := s.Counters.RejectedRate.Add(1)
+	s.logger.Debug(
+		"rejected connection: accept rate limit is exceeded",
+		"client", conn.RemoteAddr().String(), "limit", reason, "total_rejected", total,
+	)
+
+	if err := conn.Close(); err != nil {
+		s.logger.Debug("failed to close rejected connection", "client", conn.RemoteAddr().String(), "error", err)
+	}
+
+	return errLimited
+}
+
+// checkLimits closes the connection if its client has reached concurrent connections limits.
+func (s *Server) checkLimits(p *Params, conn net.Conn) error {
+	if p.ipLimit == nil {
+		return nil
+	}
+
+	reason, ok := p.ipLimit.acquire(conn.RemoteAddr())
+	if ok {
+		return nil
+	}
+
+	var total uint64
+	switch reason {
+	case limitIP:
+		total = s.Counters.RejectedIP.Add(1)
+	case limitPrefix:
+		total = s.Counters.RejectedPrefix.Add(1)
+	}
+
+	s.logger.Info(
+		"rejected connection: connections limit is reached",
+		"client", conn.RemoteAddr().String(), "limit", reason, "total_rejected", total,
+	)
+	if err := conn.Close(); err != nil {
+		s.logger.Debug("failed to close rejected connection", "client", conn.RemoteAddr().String(), "error", err)
+	}
+
+	return errLimited
+}
+
 // start starts workers to handle incoming connections.
 func (s *Server) start(p *Params, connections <-chan net.Conn, semaphore <-chan struct{}) {
 	for conn := range connections {
+		s.Counters.Accepted.Add(1)
+		p.wg.Add(1)
 		go s.handle(p, conn, semaphore)
 	}
-	s.logInfo.Printf("finished connections handling cycle")
+	s.logger.Info("finished connections handling cycle")
 }
 
 func (s *Server) handle(p *Params, conn net.Conn, semaphore <-chan struct{}) {
-	const skipError = "i/o timeout"
 	var (
 		t      = time.Now()
 		client = conn.RemoteAddr().String()
-		err    error
 	)
-	p.wg.Add(1)
-	s.logDebug.Printf("accepted connection from %s", client)
+	sess := s.sessions.add(conn, p.Timeouts)
+	logger := s.logger.With("session_id", sess.id, "client", client)
+	logger.Debug("session started")
 
 	defer func() {
+		s.sessions.remove(sess)
 		if closeErr := conn.Close(); closeErr != nil {
 			if errors.Is(closeErr, net.ErrClosed) {
-				s.logDebug.Printf("connection from %s is closed", client)
+				logger.Debug("connection is closed")
 			} else {
-				s.logInfo.Printf("failed to close connection from client %q: %v", client, closeErr)
+				logger.Warn("failed to close connection", "error", closeErr)
 			}
 		}
+		if p.ipLimit != nil {
+			p.ipLimit.release(conn.RemoteAddr())
+		}
 		<-semaphore // release the limitation
 		p.wg.Done()
 	}()
 
-	if err = s.S.ServeConn(conn); err != nil {
-		if errMsg := err.Error(); strings.HasSuffix(errMsg, skipError) {
-			s.logDebug.Printf("connection from %s is closed due to timeout: %v", client, err)
-		} else {
-			s.logInfo.Printf("failed to serve connection from client %q [%T]: %v", client, err, err)
-		}
+	err := s.S.ServeConn(sess)
+	r := &AccessRecord{
+		SessionInfo: sess.info(),
+		Reply:       sess.reply.Load(),
+		Duration:    time.Since(t),
+		Reason:      closeReason(sess.watch.Reason(), err),
+	}
+	logger = logger.With(
+		"user", r.User, "dest", r.Destination, "duration", r.Duration,
+		slog.Group("bytes", "in", r.Received, "out", r.Sent), "reason", r.Reason,
+	)
+
+	switch {
+	case r.Reason == ReasonError:
+		logger.Warn("failed to serve session", "error", err)
+	case err != nil:
+		logger.Debug("session is closed", "error", err)
+	default:
+		logger.Debug("session finished")
+	}
+
+	if p.AccessLog != nil {
+		p.AccessLog.LogAccess(r)
+	}
+}
+
+// waitSignal waits for a signal to stop the server, upgrade signals are handled by Group.
+func (s *Server) waitSignal(p *Params) {
+	sig := <-p.Sigint
+	if p.grouped {
+		s.logger.Debug("listener is stopped by group", "addr", p.Addr)
 	} else {
-		s.logDebug.Printf("connection served from %s during %v", client, time.Since(t))
+		s.logger.Info("taken signal", "signal", sig.String())
 	}
 }
 
 // waitClose waits for a signal to close the listener.
 // It's a blocking function that returns when the listener is closed and all connections are handled.
-func (s *Server) waitClose(p *Params, done <-chan struct{}) error {
-	signal := <-p.Sigint
-	s.logInfo.Printf("taken signal %v", signal)
+// Channel handled is closed when all accepted connections are passed to handlers.
+func (s *Server) waitClose(p *Params, done, handled <-chan struct{}) error {
+	s.waitSignal(p)
+	s.accepting.Store(false)
+	if !p.grouped {
+		if err := notify(notifyStopping); err != nil {
+			s.logger.Warn("failed to notify stopping", "error", err)
+		}
+	}
 
 	if err := p.listener.Close(); err != nil {
 		return fmt.Errorf("failed to close listener: %w", err)
 	}
 
 	<-done
-	s.logInfo.Println("listener is closed")
+	<-handled // sessions are not added to the wait group during drain
+	s.logger.Info("listener is closed")
 
-	p.wg.Wait()
-	s.logInfo.Println("all connections are handled")
+	s.drain(p)
+	s.logger.Info("all connections are handled")
 
 	return nil
 }
+
+// drain waits for active sessions during the drain period and closes remaining ones.
+func (s *Server) drain(p *Params) {
+	finished := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(finished)
+	}()
+
+	if p.Drain <= 0 {
+		<-finished
+		return
+	}
+
+	s.logger.Info("waiting for active sessions", "sessions", s.sessions.count(), "drain", p.Drain)
+	timer := time.NewTimer(p.Drain)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+		return
+	case <-timer.C:
+	}
+
+	n := s.sessions.terminate()
+	total := s.Counters.Terminated.Add(uint64(n))
+	s.logger.Info("drain period is over", "drain", p.Drain, "terminated", n, "total_terminated", total)
+
+	<-finished
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
-	"log"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
 	"os"
 	"strconv"
@@ -12,11 +14,15 @@ import (
 	"golang.org/x/net/proxy"
 
 	"github.com/z0rr0/gsocks5/conn"
+	"github.com/z0rr0/gsocks5/proxyproto"
 )
 
 const timeout = 250 * time.Millisecond
 
-var logger = log.New(os.Stdout, "[test] ", log.LstdFlags|log.Lshortfile|log.Lmicroseconds)
+var (
+	logger      = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}))
+	socksLogger = slog.NewLogLogger(logger.Handler(), slog.LevelInfo)
+)
 
 func run(t *testing.T, s *Server, i, port int, isErr bool, count uint32) (string, chan os.Signal) {
 	params := &Params{
@@ -91,9 +97,9 @@ func TestNew(t *testing.T) {
 			var (
 				connection net.Conn
 				cfgDialer  = &net.Dialer{Timeout: timeout * 15}
-				cfg        = &socks5.Config{Logger: logger, Dial: conn.Dial(cfgDialer, timeout, logger)}
+				cfg        = &socks5.Config{Logger: socksLogger, Dial: conn.Dial(cfgDialer, timeout, logger)}
 			)
-			s, err := New(cfg, logger, logger)
+			s, err := New(cfg, logger)
 			if err != nil {
 				tt.Errorf("case [%d] %s: unexpected error: %v", i, c.name, err)
 			}
@@ -126,3 +132,156 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+// proxyDialer sends PROXY protocol v1 header with the source address after connection.
+type proxyDialer struct {
+	source string
+}
+
+// Dial connects to the address and sends the header.
+func (d proxyDialer) Dial(network, addr string) (net.Conn, error) {
+	c, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.source == "" {
+		return c, nil
+	}
+
+	if _, err = c.Write([]byte("PROXY TCP4 " + d.source + " 127.0.0.1 40000 1080\r\n")); err != nil {
+		return nil, errors.Join(err, c.Close())
+	}
+
+	return c, nil
+}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	target := listenEcho(t)
+	s, err := New(&socks5.Config{Logger: socksLogger, Dial: conn.Dial(&net.Dialer{}, 0, logger)}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := proxyproto.ParseTrusted("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:          "127.0.0.1:0",
+		Connections:   4,
+		IPConnections: 1,
+		ProxyTrusted:  trusted,
+		Done:          make(chan struct{}),
+		Sigint:        make(chan os.Signal),
+		Timeout:       timeout,
+		Drain:         timeout,
+	}
+
+	go func() {
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	defer func() { params.Sigint <- os.Interrupt }()
+	addr := params.listener.Addr().String()
+
+	// clients from the same balancer are limited by their own addresses
+	for _, source := range []string{"192.0.2.1", "192.0.2.2"} {
+		dialer, dialerErr := proxy.SOCKS5("tcp", addr, nil, proxyDialer{source: source})
+		if dialerErr != nil {
+			t.Fatal(dialerErr)
+		}
+
+		c, dialErr := dialer.Dial("tcp", target)
+		if dialErr != nil {
+			t.Fatalf("client %s: %v", source, dialErr)
+		}
+		defer func() { _ = c.Close() }()
+
+		if _, err = c.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 4)
+		if _, err = io.ReadFull(c, buf); err != nil {
+			t.Fatalf("client %s: %v", source, err)
+		}
+	}
+
+	// trusted source without the header is rejected
+	dialer, err := proxy.SOCKS5("tcp", addr, nil, proxyDialer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, dialErr := dialer.Dial("tcp", target); dialErr == nil {
+		_ = c.Close()
+		t.Error("expected error without header")
+	}
+
+	if n := s.Counters.RejectedProxy.Load(); n != 1 {
+		t.Errorf("unexpected rejected counter %d", n)
+	}
+}
+
+func TestServer_ProxyProtocolSilent(t *testing.T) {
+	defer func(d time.Duration) { fallbackTimeout = d }(fallbackTimeout)
+	fallbackTimeout = timeout / 5
+
+	s, err := New(&socks5.Config{Logger: socksLogger}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trusted, err := proxyproto.ParseTrusted("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the handshake timeout is disabled, but the header is read with the fallback timeout
+	params := &Params{
+		Addr:         "127.0.0.1:0",
+		Connections:  4,
+		ProxyTrusted: trusted,
+		Done:         make(chan struct{}),
+		Sigint:       make(chan os.Signal),
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+	<-params.Done
+
+	c, err := net.Dial("tcp", params.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if err = c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("expected closed connection, got %v", err)
+	}
+
+	params.Sigint <- os.Interrupt
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		t.Error("server is not stopped")
+	}
+
+	if n := s.Counters.RejectedProxy.Load(); n != 1 {
+		t.Errorf("unexpected rejected counter %d", n)
+	}
+}
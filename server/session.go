@@ -0,0 +1,285 @@
+package server
+
+import (
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// sessionKey is an authentication context payload key of the session ID.
+const sessionKey = "Session"
+
+// session is a client connection with its timers.
+type session struct {
+	net.Conn
+	client      net.Conn // not tracked client connection
+	id          string
+	watch       *conn.Session
+	policy      *conn.TimeoutPolicy
+	reply       atomic.Int32 // the first SOCKS reply code or NoReply
+	mu          sync.Mutex
+	user        string
+	destination string
+	ip          string
+}
+
+// SessionInfo is a snapshot of an active client session.
+type SessionInfo struct {
+	conn.Stats
+	ID          string
+	Client      string
+	User        string // empty without authentication
+	Destination string // empty before the client request
+	IP          string // resolved destination IP address, empty before the client request
+}
+
+// setUser sets the authenticated user of the session.
+func (s *session) setUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user = user
+}
+
+// setDestination sets the requested destination address of the session and its resolved IP address.
+func (s *session) setDestination(destination, ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.destination, s.ip = destination, ip
+}
+
+// Write writes data to the client, the code of the first SOCKS reply is saved.
+// Authentication replies are 2 bytes long, so they are skipped.
+func (s *session) Write(b []byte) (int, error) {
+	if len(b) > 2 && b[0] == socks5Version {
+		s.reply.CompareAndSwap(NoReply, int32(b[1]))
+	}
+
+	return s.Conn.Write(b)
+}
+
+// CloseWrite shuts down the writing side of the client connection,
+// socks5.Server uses it to pass a half-close of the destination to the client.
+func (s *session) CloseWrite() error {
+	return conn.CloseWrite(s.Conn)
+}
+
+// info returns the session snapshot.
+func (s *session) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SessionInfo{
+		Stats:       s.watch.Stats(),
+		ID:          s.id,
+		Client:      s.client.RemoteAddr().String(),
+		User:        s.user,
+		Destination: s.destination,
+		IP:          s.ip,
+	}
+}
+
+// sessions is a registry of active client sessions.
+type sessions struct {
+	sync.Mutex
+	counter uint64
+	items   map[string]*session
+}
+
+// newSessions returns an empty sessions registry.
+func newSessions() *sessions {
+	return &sessions{items: make(map[string]*session)}
+}
+
+// add registers a new session of the client connection with global timeouts of the policy.
+func (ss *sessions) add(c net.Conn, policy *conn.TimeoutPolicy) *session {
+	watch := conn.NewSession(policy.User(""))
+	s := &session{Conn: watch.Track(c), client: c, watch: watch, policy: policy}
+	s.reply.Store(NoReply)
+
+	ss.Lock()
+	defer ss.Unlock()
+
+	ss.counter++
+	s.id = strconv.FormatUint(ss.counter, 10)
+	ss.items[s.id] = s
+
+	return s
+}
+
+// remove unregisters the session and stops its timers.
+func (ss *sessions) remove(s *session) {
+	s.watch.Stop()
+
+	ss.Lock()
+	defer ss.Unlock()
+
+	delete(ss.items, s.id)
+}
+
+// count returns a number of active sessions.
+func (ss *sessions) count() int {
+	ss.Lock()
+	defer ss.Unlock()
+
+	return len(ss.items)
+}
+
+// terminate closes all active sessions and returns their number.
+// Clients get the end of stream before closing, so they can distinguish it from a network failure.
+func (ss *sessions) terminate() int {
+	items := ss.list()
+	for _, s := range items {
+		_ = conn.CloseWrite(s.client) // the connection is closed anyway
+		_ = s.watch.Close(conn.ReasonShutdown)
+	}
+
+	return len(items)
+}
+
+// list returns active sessions.
+func (ss *sessions) list() []*session {
+	ss.Lock()
+	defer ss.Unlock()
+
+	items := make([]*session, 0, len(ss.items))
+	for _, s := range ss.items {
+		items = append(items, s)
+	}
+
+	return items
+}
+
+// get returns a session by ID or nil if it is not found.
+func (ss *sessions) get(id string) *session {
+	if ss == nil || id == "" {
+		return nil
+	}
+
+	ss.Lock()
+	defer ss.Unlock()
+
+	return ss.items[id]
+}
+
+// sessionAuth is an authenticator wrapper that binds the authentication context to the client session
+// and applies timeouts of the authenticated user.
+type sessionAuth struct {
+	socks5.Authenticator
+	counters *Counters
+}
+
+// Authenticate authenticates the client by the wrapped authenticator and adds the session ID to the context.
+// The writer is a connection passed to socks5.Server.ServeConn, so it is the session.
+func (a *sessionAuth) Authenticate(reader io.Reader, writer io.Writer) (*socks5.AuthContext, error) {
+	ac, err := a.Authenticator.Authenticate(reader, writer)
+	if err != nil {
+		a.counters.AuthFailure.Add(1)
+		return nil, err
+	}
+	a.counters.AuthSuccess.Add(1)
+
+	s, ok := writer.(*session)
+	if !ok {
+		return ac, nil
+	}
+
+	if ac.Payload == nil {
+		ac.Payload = make(map[string]string, 1)
+	}
+	ac.Payload[sessionKey] = s.id
+	s.setUser(ac.Payload["Username"])
+
+	if err = s.watch.SetTimeouts(s.policy.User(ac.Payload["Username"])); err != nil {
+		return nil, err
+	}
+
+	return ac, nil
+}
+
+// defaultMethods returns the configured authenticators or the socks5 default one.
+func defaultMethods(cfg *socks5.Config) []socks5.Authenticator {
+	if len(cfg.AuthMethods) > 0 {
+		return cfg.AuthMethods
+	}
+
+	if cfg.Credentials != nil {
+		return []socks5.Authenticator{&socks5.UserPassAuthenticator{Credentials: cfg.Credentials}}
+	}
+
+	return []socks5.Authenticator{&socks5.NoAuthAuthenticator{}}
+}
+
+// authMethods returns the configured authenticators or the socks5 default one wrapped by sessionAuth,
+// authentication results are counted by the counters.
+func authMethods(cfg *socks5.Config, counters *Counters) []socks5.Authenticator {
+	methods := defaultMethods(cfg)
+	result := make([]socks5.Authenticator, len(methods))
+	for i, m := range methods {
+		result[i] = &sessionAuth{Authenticator: m, counters: counters}
+	}
+
+	return result
+}
+
+// ListSessions returns snapshots of active sessions ordered by their start time.
+func (s *Server) ListSessions() []SessionInfo {
+	items := s.sessions.list()
+	result := make([]SessionInfo, len(items))
+
+	for i, item := range items {
+		result[i] = item.info()
+	}
+
+	slices.SortFunc(result, func(a, b SessionInfo) int {
+		return a.Started.Compare(b.Started)
+	})
+
+	return result
+}
+
+// KillSession closes the active session by ID, it returns false if the session is not found.
+func (s *Server) KillSession(id string) bool {
+	item := s.sessions.get(id)
+	if item == nil {
+		return false
+	}
+
+	s.kill(item)
+	return true
+}
+
+// KillUser closes all active sessions of the user and returns their number.
+func (s *Server) KillUser(user string) int {
+	var n int
+
+	for _, item := range s.sessions.list() {
+		if item.info().User == user {
+			s.kill(item)
+			n++
+		}
+	}
+
+	return n
+}
+
+// kill closes the session connections.
+func (s *Server) kill(item *session) {
+	info := item.info()
+	s.logger.Info(
+		"session is killed",
+		"session_id", info.ID, "client", info.Client, "user", info.User, "dest", info.Destination,
+	)
+
+	if err := item.watch.Close(conn.ReasonKilled); err != nil {
+		s.logger.Debug("failed to close killed session", "session_id", info.ID, "error", err)
+	}
+}
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+func TestSessionAuth(t *testing.T) {
+	var (
+		ss     = newSessions()
+		c1, c2 = net.Pipe()
+	)
+	defer func() { _ = c1.Close() }()
+	defer func() { _ = c2.Close() }()
+
+	s := ss.add(c1, nil)
+	if ss.get(s.id) != s || ss.get("") != nil {
+		t.Fatal("unexpected session registry content")
+	}
+
+	var counters Counters
+	methods := authMethods(&socks5.Config{}, &counters)
+	if n := len(methods); n != 1 {
+		t.Fatalf("expected one authenticator, got %d", n)
+	}
+
+	go func() { _, _ = io.Copy(io.Discard, c2) }()
+
+	ac, err := methods[0].Authenticate(bytes.NewReader(nil), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id := ac.Payload[sessionKey]; id != s.id {
+		t.Errorf("unexpected session ID %q", id)
+	}
+
+	if n := counters.AuthSuccess.Load(); n != 1 {
+		t.Errorf("unexpected auth successes %d", n)
+	}
+
+	ss.remove(s)
+	if ss.get(s.id) != nil {
+		t.Error("expected removed session")
+	}
+
+	cfg := &socks5.Config{Credentials: socks5.StaticCredentials{"user": "secret"}}
+	if methods = authMethods(cfg, &counters); len(methods) != 1 {
+		t.Fatalf("expected one authenticator, got %d", len(methods))
+	}
+
+	if code := methods[0].GetCode(); code != socks5.UserPassAuth {
+		t.Errorf("unexpected auth code %d", code)
+	}
+
+	// version, username "user" and wrong password
+	request := []byte{passwordVersion, 4, 'u', 's', 'e', 'r', 5, 'w', 'r', 'o', 'n', 'g'}
+	if _, err = methods[0].Authenticate(bytes.NewReader(request), io.Discard); err == nil {
+		t.Error("expected authentication error")
+	}
+
+	if n := counters.AuthFailure.Load(); n != 1 {
+		t.Errorf("unexpected auth failures %d", n)
+	}
+}
+
+func TestServer_Timeouts(t *testing.T) {
+	const idle = 3 * timeout
+
+	target := listenEcho(t)
+	s, err := New(&socks5.Config{Logger: socksLogger, Dial: conn.Dial(&net.Dialer{}, 0, logger)}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := conn.NewTimeoutPolicy(conn.Timeouts{Handshake: timeout, Idle: idle}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:        "127.0.0.1:0",
+		Connections: 1,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Timeout:     timeout,
+		Timeouts:    policy,
+	}
+
+	go func() {
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	defer func() { params.Sigint <- os.Interrupt }()
+
+	dialer, err := proxy.SOCKS5("tcp", params.listener.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	// the handshake deadline is cleared for the established session
+	time.Sleep(timeout * 2)
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	if _, err = io.ReadFull(c, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.SetReadDeadline(time.Now().Add(idle * 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the idle session is closed by the server
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	if d := time.Since(started); d < idle-timeout/5 {
+		t.Errorf("session is closed too early: %v", d)
+	}
+}
+
+// listenEcho starts an echo server and returns its address.
+func listenEcho(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			c, e := listener.Accept()
+			if e != nil {
+				return
+			}
+
+			go func() {
+				_, _ = io.Copy(c, c)
+				_ = c.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestServer_Drain(t *testing.T) {
+	target := listenEcho(t)
+	s, err := New(&socks5.Config{Logger: socksLogger, Dial: conn.Dial(&net.Dialer{}, 0, logger)}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:        "127.0.0.1:0",
+		Connections: 2,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Timeout:     timeout,
+		Drain:       timeout,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	dialer, err := proxy.SOCKS5("tcp", params.listener.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if n := s.Sessions(); n != 1 {
+		t.Errorf("expected 1 active session, got %d", n)
+	}
+
+	// block policy reserves a slot for the next accepted connection
+	if used, capacity := s.Slots(); used != 2 || capacity != 2 {
+		t.Errorf("unexpected slots %d/%d", used, capacity)
+	}
+
+	if n := s.Counters.Accepted.Load(); n != 1 {
+		t.Errorf("expected 1 accepted connection, got %d", n)
+	}
+
+	started := time.Now()
+	params.Sigint <- os.Interrupt
+
+	// the session is active during the drain period
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = io.ReadFull(c, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.SetReadDeadline(time.Now().Add(timeout * 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	<-stopped
+	if d := time.Since(started); d < timeout {
+		t.Errorf("server is stopped before the drain period: %v", d)
+	}
+
+	if n := s.Counters.Terminated.Load(); n != 1 {
+		t.Errorf("expected 1 terminated session, got %d", n)
+	}
+}
+
+func TestServer_ListSessions(t *testing.T) {
+	target := listenEcho(t)
+	cfg := &socks5.Config{
+		Logger:      socksLogger,
+		Credentials: socks5.StaticCredentials{"alice": "secret"},
+		Dial:        conn.Dial(&net.Dialer{}, 0, logger),
+	}
+
+	s, err := New(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:        "127.0.0.1:0",
+		Connections: 2,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Timeout:     timeout,
+		Drain:       timeout,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	defer func() {
+		params.Sigint <- os.Interrupt
+		<-stopped
+	}()
+
+	auth := &proxy.Auth{User: "alice", Password: "secret"}
+	dialer, err := proxy.SOCKS5("tcp", params.listener.Addr().String(), auth, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if err = echo(c); err != nil {
+		t.Fatal(err)
+	}
+
+	items := s.ListSessions()
+	if n := len(items); n != 1 {
+		t.Fatalf("expected 1 session, got %d", n)
+	}
+
+	info := items[0]
+	if info.User != "alice" || info.Destination != target || info.Client != c.LocalAddr().String() {
+		t.Errorf("unexpected session %+v", info)
+	}
+
+	if info.Sent != 4 || info.Received != 4 {
+		t.Errorf("unexpected bytes: sent=%d, received=%d", info.Sent, info.Received)
+	}
+
+	if s.KillSession("unknown") || s.KillUser("bob") != 0 {
+		t.Error("unexpected killed session")
+	}
+
+	if n := s.KillUser("alice"); n != 1 {
+		t.Errorf("expected 1 killed session, got %d", n)
+	}
+
+	if err = c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = c.Read(make([]byte, 1)); err == nil {
+		t.Error("expected closed connection")
+	}
+}
+
+func TestServer_HalfClose(t *testing.T) {
+	target := listenEcho(t)
+	s, err := New(&socks5.Config{Logger: socksLogger, Dial: conn.Dial(&net.Dialer{}, 0, logger)}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &Params{
+		Addr:        "127.0.0.1:0",
+		Connections: 2,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Timeout:     timeout,
+		Drain:       timeout,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	defer func() {
+		params.Sigint <- os.Interrupt
+		<-stopped
+	}()
+
+	dialer, err := proxy.SOCKS5("tcp", params.listener.Addr().String(), nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close() }()
+
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the echo server closes the connection after the end of stream from the client,
+	// both half-closes are passed through the session
+	if err = conn.CloseWrite(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.SetReadDeadline(time.Now().Add(timeout * 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	if s := string(data); s != "ping" {
+		t.Errorf("unexpected data %q", s)
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	listenFDsStart = 3 // first file descriptor passed by systemd socket activation
+
+	notifyStopping = "STOPPING=1"
+	notifyWatchdog = "WATCHDOG=1"
+)
+
+// ErrSystemd is returned when systemd environment is invalid.
+var ErrSystemd = errors.New("invalid systemd environment")
+
+// SystemdListeners returns listeners passed by systemd socket activation, it is empty without activation.
+// Environment variables are cleared to not pass them to child processes.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	err := errors.Join(os.Unsetenv("LISTEN_PID"), os.Unsetenv("LISTEN_FDS"), os.Unsetenv("LISTEN_FDNAMES"))
+	if err != nil {
+		return nil, errors.Join(ErrSystemd, err)
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, errors.Join(ErrSystemd, fmt.Errorf("invalid LISTEN_FDS %q", fds))
+	}
+
+	listeners, err := fileListeners(listenFDsStart, n)
+	if err != nil {
+		return nil, errors.Join(ErrSystemd, err)
+	}
+
+	return listeners, nil
+}
+
+// fileListeners returns n listeners from file descriptors starting from the first one.
+func fileListeners(first, n int) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, n)
+
+	for fd := first; fd < first+n; fd++ {
+		f := os.NewFile(uintptr(fd), "listener_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(f)
+
+		// the listener has own duplicated descriptor
+		if err = errors.Join(err, f.Close()); err != nil {
+			for _, l := range listeners {
+				err = errors.Join(err, l.Close())
+			}
+			return nil, fmt.Errorf("file descriptor %d: %w", fd, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// notify sends the state to systemd notify socket, it does nothing if the service is not of notify type.
+func notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return errors.Join(ErrSystemd, err)
+	}
+
+	_, err = c.Write([]byte(state))
+	if err = errors.Join(err, c.Close()); err != nil {
+		return errors.Join(ErrSystemd, err)
+	}
+
+	return nil
+}
+
+// notifyReady notifies systemd that the service is ready.
+// The main process ID is also sent, because it is changed after upgrade.
+func notifyReady() error {
+	return notify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()))
+}
+
+// watchdogInterval returns a period of watchdog notifications or zero if the watchdog is disabled.
+// It's a half of the systemd timeout as recommended.
+func watchdogInterval() time.Duration {
+	usec, pid := os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID")
+	if usec == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+
+	return time.Duration(n) * time.Microsecond / 2
+}
+
+// watchdog sends keep-alive notifications to systemd until the context is done.
+func watchdog(ctx context.Context, logger *slog.Logger) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := notify(notifyWatchdog); err != nil {
+				logger.Warn("failed to notify systemd watchdog", "error", err)
+			}
+		}
+	}
+}
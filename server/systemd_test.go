@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notifySocket listens systemd notifications and returns a channel of received states.
+func notifySocket(t *testing.T) <-chan string {
+	name := filepath.Join(t.TempDir(), "notify.sock")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	t.Setenv("NOTIFY_SOCKET", name)
+
+	states := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, e := c.Read(buf)
+			if e != nil {
+				close(states)
+				return
+			}
+			states <- string(buf[:n])
+		}
+	}()
+
+	return states
+}
+
+// receive returns the next state or fails after timeout.
+func receive(t *testing.T, states <-chan string) string {
+	select {
+	case state := <-states:
+		return state
+	case <-time.After(timeout * 4):
+		t.Fatal("notification is not received")
+		return ""
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := notifyReady(); err != nil {
+		t.Errorf("unexpected error without socket: %v", err)
+	}
+
+	states := notifySocket(t)
+	if err := notify(notifyStopping); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := receive(t, states); state != notifyStopping {
+		t.Errorf("unexpected state %q", state)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "not_existing.sock"))
+	if err := notifyReady(); !errors.Is(err, ErrSystemd) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	testCases := []struct {
+		usec, pid string
+		expected  time.Duration
+	}{
+		{},
+		{usec: "bad"},
+		{usec: "2000000", pid: "1"},
+		{usec: "2000000", expected: time.Second},
+		{usec: "100000", pid: pid, expected: 50 * time.Millisecond},
+	}
+
+	for i, tc := range testCases {
+		t.Setenv("WATCHDOG_USEC", tc.usec)
+		t.Setenv("WATCHDOG_PID", tc.pid)
+
+		if d := watchdogInterval(); d != tc.expected {
+			t.Errorf("case %d: expected %v, got %v", i, tc.expected, d)
+		}
+	}
+
+	states := notifySocket(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan struct{})
+	go func() {
+		watchdog(ctx, logger)
+		close(stopped)
+	}()
+
+	if state := receive(t, states); state != notifyWatchdog {
+		t.Errorf("unexpected state %q", state)
+	}
+
+	cancel()
+	<-stopped
+}
+
+func TestSystemdListeners(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	if listeners, err := SystemdListeners(); err != nil || len(listeners) != 0 {
+		t.Fatalf("expected no listeners: %v", err)
+	}
+
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", "1")
+	if listeners, err := SystemdListeners(); err != nil || len(listeners) != 0 {
+		t.Fatalf("expected no listeners for other process: %v", err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if _, err := SystemdListeners(); !errors.Is(err, ErrSystemd) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("expected cleared environment")
+	}
+}
+
+func TestServer_Notify(t *testing.T) {
+	states := notifySocket(t)
+	s, addr := serve(t, OverflowBlock, 0)
+
+	if state := receive(t, states); !strings.HasPrefix(state, "READY=1\nMAINPID=") {
+		t.Errorf("unexpected state %q", state)
+	}
+
+	if s == nil || addr == "" {
+		t.Fatal("server is not started")
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-socks5"
+)
+
+// UnixPrefix is an address prefix of unix domain socket listeners, for example "unix:/run/gsocks5.sock".
+const UnixPrefix = "unix:"
+
+// staleTimeout is a max waiting time to check that an existing socket file is not used.
+const staleTimeout = time.Second
+
+// ErrUnixSocket is returned when the unix domain socket can not be prepared.
+var ErrUnixSocket = errors.New("invalid unix socket")
+
+// unixPath returns the socket path of the unix address.
+func unixPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, UnixPrefix)
+	return path, ok && path != ""
+}
+
+// listenUnix listens on the unix domain socket, removes a stale socket file and sets the file mode and owner.
+func listenUnix(ctx context.Context, p *Params, path string) (net.Listener, error) {
+	if err := removeStale(path); err != nil {
+		return nil, err
+	}
+
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = socketFile(path, p.SocketMode, p.SocketOwner); err != nil {
+		return nil, errors.Join(err, listener.Close())
+	}
+
+	return listener, nil
+}
+
+// removeStale removes the socket file if no one listens on it.
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return errors.Join(ErrUnixSocket, err)
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.Join(ErrUnixSocket, fmt.Errorf("file %s is not a socket", path))
+	}
+
+	c, err := net.DialTimeout("unix", path, staleTimeout)
+	if err == nil {
+		return errors.Join(ErrUnixSocket, fmt.Errorf("socket %s is in use", path), c.Close())
+	}
+
+	if err = os.Remove(path); err != nil {
+		return errors.Join(ErrUnixSocket, err)
+	}
+
+	return nil
+}
+
+// socketFile sets the socket file mode and owner, zero mode and empty owner are not changed.
+func socketFile(path string, mode os.FileMode, owner string) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return errors.Join(ErrUnixSocket, err)
+		}
+	}
+
+	if owner == "" {
+		return nil
+	}
+
+	uid, gid, err := lookupOwner(owner)
+	if err != nil {
+		return errors.Join(ErrUnixSocket, err)
+	}
+
+	if err = os.Chown(path, uid, gid); err != nil {
+		return errors.Join(ErrUnixSocket, err)
+	}
+
+	return nil
+}
+
+// lookupOwner returns UID and GID of the owner in format "user[:group]", names or numeric IDs are allowed.
+// GID is -1 if the group is not set, so it is not changed.
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+
+	uid, err := strconv.Atoi(userName)
+	if err != nil {
+		u, lookupErr := user.Lookup(userName)
+		if lookupErr != nil {
+			return 0, 0, lookupErr
+		}
+
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("invalid UID %q of user %q: %w", u.Uid, userName, err)
+		}
+	}
+
+	if groupName == "" {
+		return uid, -1, nil
+	}
+
+	gid, err := strconv.Atoi(groupName)
+	if err != nil {
+		g, lookupErr := user.LookupGroup(groupName)
+		if lookupErr != nil {
+			return 0, 0, lookupErr
+		}
+
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("invalid GID %q of group %q: %w", g.Gid, groupName, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// keepSockets disables removing of unix sockets files on close, they are used by the new process after upgrade.
+func keepSockets(listeners []net.Listener) {
+	for _, listener := range listeners {
+		if ul, ok := listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+// peerAuthenticator authenticates unix socket clients by their UID without password.
+// Other clients are authenticated by the next authenticator.
+type peerAuthenticator struct {
+	users map[uint32]string
+	next  socks5.Authenticator
+}
+
+// GetCode returns "no authentication" method code, peers do not send credentials.
+func (a *peerAuthenticator) GetCode() uint8 {
+	return authNone
+}
+
+// Authenticate returns the user of the client UID or calls the next authenticator.
+// The next one can select another method, clients which did not offer it reject the reply.
+func (a *peerAuthenticator) Authenticate(reader io.Reader, writer io.Writer) (*socks5.AuthContext, error) {
+	if s, ok := writer.(*session); ok {
+		if uid, found := peerUID(s.client); found {
+			if userName, exists := a.users[uid]; exists {
+				if _, err := writer.Write([]byte{socks5Version, authNone}); err != nil {
+					return nil, err
+				}
+
+				return &socks5.AuthContext{Method: authNone, Payload: map[string]string{"Username": userName}}, nil
+			}
+		}
+	}
+
+	return a.next.Authenticate(reader, writer)
+}
+
+// PeerAuth returns authenticators of the config with unix socket clients authentication by their UID.
+// The result should be set as config AuthMethods.
+func PeerAuth(cfg *socks5.Config, users map[uint32]string) []socks5.Authenticator {
+	methods := defaultMethods(cfg)
+	if len(users) == 0 {
+		return methods
+	}
+
+	peer := &peerAuthenticator{users: users, next: methods[0]}
+	result := []socks5.Authenticator{peer}
+
+	for _, m := range methods {
+		if m.GetCode() == authNone {
+			peer.next = m
+			continue
+		}
+		result = append(result, m)
+	}
+
+	return result
+}
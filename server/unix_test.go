@@ -0,0 +1,241 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// socketPath returns a short path of a unix socket in a temporary directory.
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "gs5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "s.sock")
+}
+
+func TestUnixPath(t *testing.T) {
+	if path, ok := unixPath("unix:/run/gsocks5.sock"); !ok || path != "/run/gsocks5.sock" {
+		t.Errorf("unexpected result %q, %v", path, ok)
+	}
+
+	for _, addr := range []string{"unix:", "127.0.0.1:1080", ":1080"} {
+		if _, ok := unixPath(addr); ok {
+			t.Errorf("unexpected unix address %q", addr)
+		}
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	path := socketPath(t)
+	if err := removeStale(path); err != nil {
+		t.Errorf("unexpected error for absent file: %v", err)
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := removeStale(path); !errors.Is(err, ErrUnixSocket) {
+		t.Errorf("unexpected error for regular file: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = removeStale(path); !errors.Is(err, ErrUnixSocket) {
+		t.Errorf("unexpected error for used socket: %v", err)
+	}
+
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = removeStale(path); err != nil {
+		t.Errorf("unexpected error for stale socket: %v", err)
+	}
+
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected removed socket: %v", err)
+	}
+}
+
+func TestLookupOwner(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid, err := strconv.Atoi(current.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gid, err := strconv.Atoi(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name  string
+		owner string
+		gid   int
+	}{
+		{name: "name", owner: current.Username, gid: -1},
+		{name: "uid", owner: current.Uid, gid: -1},
+		{name: "group", owner: current.Uid + ":" + current.Gid, gid: gid},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, g, e := lookupOwner(tc.owner)
+			if e != nil || u != uid || g != tc.gid {
+				t.Errorf("unexpected result %d, %d, %v", u, g, e)
+			}
+		})
+	}
+
+	if _, _, err = lookupOwner("unknown-local-user"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// serveUnix starts a server on a unix socket with the peer users and credentials.
+func serveUnix(t *testing.T, peers map[uint32]string) string {
+	cfg := &socks5.Config{
+		Logger:      socksLogger,
+		Credentials: socks5.StaticCredentials{"user": "password"},
+		Dial:        conn.Dial(&net.Dialer{}, 0, logger),
+	}
+	cfg.AuthMethods = PeerAuth(cfg, peers)
+
+	s, err := New(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := socketPath(t)
+	params := &Params{
+		Addr:        UnixPrefix + path,
+		Connections: 2,
+		SocketMode:  0o600,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Timeout:     timeout,
+		Drain:       timeout,
+	}
+
+	go func() {
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	t.Cleanup(func() { params.Sigint <- os.Interrupt })
+
+	return path
+}
+
+// echo checks that the connection is proxied to the echo server.
+func echo(c net.Conn) error {
+	if _, err := c.Write([]byte("ping")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+
+	if s := string(buf); s != "ping" {
+		return errors.New("unexpected response " + s)
+	}
+
+	return nil
+}
+
+func TestServer_Unix(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are supported only on linux")
+	}
+
+	target := listenEcho(t)
+	uid := uint32(os.Getuid())
+
+	path := serveUnix(t, map[uint32]string{uid: "user"})
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("unexpected socket mode %v", mode)
+	}
+
+	// the peer is authenticated by its UID without password
+	dialer, err := proxy.SOCKS5("unix", path, nil, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = echo(c); err != nil {
+		t.Error(err)
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// unknown peers use password authentication
+	path = serveUnix(t, map[uint32]string{uid + 1: "user"})
+	if dialer, err = proxy.SOCKS5("unix", path, nil, proxy.Direct); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err = dialer.Dial("tcp", target); err == nil {
+		_ = c.Close()
+		t.Error("expected authentication error")
+	}
+
+	auth := &proxy.Auth{User: "user", Password: "password"}
+	if dialer, err = proxy.SOCKS5("unix", path, auth, proxy.Direct); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err = dialer.Dial("tcp", target); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = echo(c); err != nil {
+		t.Error(err)
+	}
+	if err = c.Close(); err != nil {
+		t.Error(err)
+	}
+}
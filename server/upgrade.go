@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+const (
+	envListenFDs   = "GSOCKS5_LISTEN_FDS" // number of inherited listeners file descriptors
+	envReadyFD     = "GSOCKS5_READY_FD"   // pipe file descriptor to report readiness of the new process
+	upgradeTimeout = 30 * time.Second     // max waiting time of the new process readiness
+)
+
+// ErrUpgrade is returned when the new process can not be started or inherit the listener.
+var ErrUpgrade = errors.New("failed to upgrade")
+
+// upgrade starts the current executable with the same arguments and inherited listeners.
+// Listeners file descriptors start from 3, the next one is a readiness pipe.
+// It returns the new process ID when it reports readiness.
+func upgrade(listeners []net.Listener, logger *slog.Logger) (int, error) {
+	files := make([]*os.File, 0, len(listeners)+1)
+	defer func() {
+		for _, f := range files {
+			if e := f.Close(); e != nil {
+				logger.Debug("failed to close file", "file", f.Name(), "error", e)
+			}
+		}
+	}()
+
+	for _, listener := range listeners {
+		fl, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, errors.Join(ErrUpgrade, fmt.Errorf("listener %T has no file descriptor", listener))
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return 0, errors.Join(ErrUpgrade, err)
+		}
+
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, errors.Join(ErrUpgrade, err)
+	}
+	defer func() {
+		if e := r.Close(); e != nil {
+			logger.Debug("failed to close readiness pipe", "error", e)
+		}
+	}()
+
+	files = append(files, w) // it is closed after the process start
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, errors.Join(ErrUpgrade, err)
+	}
+
+	// #nosec G204, the same executable with the same arguments
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(
+		os.Environ(),
+		envListenFDs+"="+strconv.Itoa(len(listeners)),
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(listeners)),
+	)
+
+	if err = cmd.Start(); err != nil {
+		return 0, errors.Join(ErrUpgrade, err)
+	}
+
+	// the new process has own copy of the pipe, so it is closed on the process failure
+	files = files[:len(files)-1]
+	if err = w.Close(); err != nil {
+		logger.Debug("failed to close readiness pipe", "error", err)
+	}
+
+	pid := cmd.Process.Pid
+	if err = waitReady(r, upgradeTimeout); err != nil {
+		return 0, errors.Join(ErrUpgrade, fmt.Errorf("process %d is not ready: %w", pid, err), cmd.Process.Kill())
+	}
+
+	// the new process is not a child anymore, it works after this one is stopped
+	keepSockets(listeners)
+	if err = cmd.Process.Release(); err != nil {
+		logger.Debug("failed to release process", "pid", pid, "error", err)
+	}
+
+	return pid, nil
+}
+
+// waitReady waits for a byte from the readiness pipe, the pipe is closed without it if the process failed.
+func waitReady(r *os.File, timeout time.Duration) error {
+	if err := r.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Inherited returns listeners inherited from the previous process during upgrade
+// and a function to report readiness to it. Listeners are empty if there is no upgrade.
+func Inherited() ([]net.Listener, func(), error) {
+	return inherited(listenFDsStart)
+}
+
+// inherited returns inherited listeners which file descriptors start from the first one.
+func inherited(first int) ([]net.Listener, func(), error) {
+	listenFDs, readyFD := os.Getenv(envListenFDs), os.Getenv(envReadyFD)
+	if listenFDs == "" {
+		return nil, nil, nil
+	}
+
+	// not inherit them by next upgrades
+	if err := errors.Join(os.Unsetenv(envListenFDs), os.Unsetenv(envReadyFD)); err != nil {
+		return nil, nil, errors.Join(ErrUpgrade, err)
+	}
+
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 1 {
+		return nil, nil, errors.Join(ErrUpgrade, fmt.Errorf("invalid listeners number %q", listenFDs))
+	}
+
+	rfd, err := strconv.ParseUint(readyFD, 10, 32)
+	if err != nil {
+		return nil, nil, errors.Join(ErrUpgrade, fmt.Errorf("invalid readiness descriptor %q: %w", readyFD, err))
+	}
+
+	listeners, err := fileListeners(first, n)
+	if err != nil {
+		return nil, nil, errors.Join(ErrUpgrade, err)
+	}
+
+	pipe := os.NewFile(uintptr(rfd), "ready")
+	ready := func() {
+		_, _ = pipe.Write([]byte{1}) // the previous process stops waiting on close anyway
+		_ = pipe.Close()
+	}
+
+	return listeners, ready, nil
+}
@@ -0,0 +1,144 @@
+//go:build unix
+
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dupFD returns a duplicate of the file descriptor and closes the file,
+// so the descriptor is owned only by the caller and is not closed by the file finalizer.
+func dupFD(t *testing.T, f *os.File) int {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return fd
+}
+
+func TestInherited(t *testing.T) {
+	listeners, ready, err := Inherited()
+	if err != nil || listeners != nil || ready != nil {
+		t.Fatalf("expected no inherited listener: %v", err)
+	}
+
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = origin.Close() }()
+
+	lf, err := origin.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	t.Setenv(envListenFDs, "1")
+	t.Setenv(envReadyFD, strconv.Itoa(dupFD(t, w)))
+
+	if listeners, ready, err = inherited(dupFD(t, lf)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listeners); n != 1 {
+		t.Fatalf("unexpected listeners number %d", n)
+	}
+	defer func() { _ = listeners[0].Close() }()
+
+	if os.Getenv(envListenFDs) != "" || os.Getenv(envReadyFD) != "" {
+		t.Error("expected cleared environment")
+	}
+
+	if a, b := listeners[0].Addr().String(), origin.Addr().String(); a != b {
+		t.Errorf("unexpected listener address %s, expected %s", a, b)
+	}
+
+	ready()
+	if err = waitReady(r, timeout); err != nil {
+		t.Errorf("expected readiness: %v", err)
+	}
+
+	t.Setenv(envListenFDs, "bad")
+	if _, _, err = Inherited(); !errors.Is(err, ErrUpgrade) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	if err = waitReady(r, timeout/5); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	// the process is failed before readiness
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = waitReady(r, time.Second); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+// noFileListener is a listener without file descriptor.
+type noFileListener struct {
+	net.Listener
+}
+
+func TestGroup_WaitSignal(t *testing.T) {
+	var (
+		g          = &Group{logger: logger, items: []groupItem{{p: &Params{listener: noFileListener{}}}}}
+		sigint     = make(chan os.Signal, 1)
+		sigupgrade = make(chan os.Signal, 1)
+		saved      = make(chan struct{}, 1)
+	)
+	g.BeforeUpgrade = func() { saved <- struct{}{} }
+
+	if _, err := upgrade(g.listeners(), logger); !errors.Is(err, ErrUpgrade) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// failed upgrade does not stop the group
+	sigupgrade <- os.Interrupt
+
+	stopped := make(chan struct{})
+	go func() {
+		g.waitSignal(sigint, sigupgrade)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("group is stopped after failed upgrade")
+	case <-time.After(timeout):
+	}
+
+	sigint <- os.Interrupt
+	<-stopped
+
+	if n := len(saved); n != 1 {
+		t.Errorf("expected state saving before upgrade, got %d", n)
+	}
+}
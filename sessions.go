@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/armon/go-socks5"
+
+	"github.com/z0rr0/gsocks5/server"
+)
+
+// namedServer is a listener server with its name.
+type namedServer struct {
+	name string
+	s    *server.Server
+}
+
+// sessionView is a JSON view of the active session.
+type sessionView struct {
+	Listener    string    `json:"listener"`
+	ID          string    `json:"id"`
+	Client      string    `json:"client"`
+	User        string    `json:"user,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Started     time.Time `json:"started"`
+	Duration    string    `json:"duration"`
+	Idle        string    `json:"idle"`
+	Received    uint64    `json:"received"`
+	Sent        uint64    `json:"sent"`
+}
+
+// sessionFilter selects sessions by request parameters, empty fields match all sessions.
+type sessionFilter struct {
+	listener    string
+	user        string
+	client      string        // client IP address
+	destination string        // substring of the destination address
+	idle        time.Duration // min idle time
+}
+
+// match returns true if the session matches the filter.
+func (f *sessionFilter) match(listener string, info *server.SessionInfo, now time.Time) bool {
+	if f.listener != "" && f.listener != listener {
+		return false
+	}
+
+	if f.user != "" && f.user != info.User {
+		return false
+	}
+
+	if f.client != "" {
+		host, _, err := net.SplitHostPort(info.Client)
+		if err != nil || host != f.client {
+			return false
+		}
+	}
+
+	if f.destination != "" && !strings.Contains(info.Destination, f.destination) {
+		return false
+	}
+
+	return now.Sub(info.Last) >= f.idle
+}
+
+// sessionsAPI is an admin API to list and kill active sessions of all listeners.
+type sessionsAPI struct {
+	servers     []namedServer
+	credentials socks5.CredentialStore
+}
+
+// register adds API handlers to the mux, all of them require basic authentication.
+func (api *sessionsAPI) register(mux *http.ServeMux) {
+	mux.Handle("GET /sessions", api.authenticated(api.list))
+	mux.Handle("DELETE /sessions", api.authenticated(api.killUser))
+	mux.Handle("DELETE /sessions/{listener}/{id}", api.authenticated(api.kill))
+}
+
+// authenticated checks basic authentication credentials before the handler call.
+func (api *sessionsAPI) authenticated(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || !api.credentials.Valid(user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gsocks5"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	})
+}
+
+// filter returns a session filter from the request query.
+func (api *sessionsAPI) filter(r *http.Request) (*sessionFilter, error) {
+	q := r.URL.Query()
+	f := &sessionFilter{
+		listener:    q.Get("listener"),
+		user:        q.Get("user"),
+		client:      q.Get("client"),
+		destination: q.Get("destination"),
+	}
+
+	if value := q.Get("idle"); value != "" {
+		idle, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+		f.idle = idle
+	}
+
+	return f, nil
+}
+
+// list replies active sessions matched by the filter.
+func (api *sessionsAPI) list(w http.ResponseWriter, r *http.Request) {
+	f, err := api.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		now   = time.Now()
+		views = make([]sessionView, 0)
+	)
+
+	for _, ns := range api.servers {
+		for _, info := range ns.s.ListSessions() {
+			if !f.match(ns.name, &info, now) {
+				continue
+			}
+
+			views = append(views, sessionView{
+				Listener:    ns.name,
+				ID:          info.ID,
+				Client:      info.Client,
+				User:        info.User,
+				Destination: info.Destination,
+				Started:     info.Started,
+				Duration:    now.Sub(info.Started).Round(time.Millisecond).String(),
+				Idle:        now.Sub(info.Last).Round(time.Millisecond).String(),
+				Received:    info.Received,
+				Sent:        info.Sent,
+			})
+		}
+	}
+
+	reply(w, views)
+}
+
+// kill closes the session by listener name and session ID.
+func (api *sessionsAPI) kill(w http.ResponseWriter, r *http.Request) {
+	listener, id := r.PathValue("listener"), r.PathValue("id")
+
+	for _, ns := range api.servers {
+		if ns.name == listener && ns.s.KillSession(id) {
+			reply(w, map[string]int{"killed": 1})
+			return
+		}
+	}
+
+	http.Error(w, "session not found", http.StatusNotFound)
+}
+
+// killUser closes all sessions of the user, the listener parameter limits them by one listener.
+func (api *sessionsAPI) killUser(w http.ResponseWriter, r *http.Request) {
+	var (
+		n        int
+		user     = r.URL.Query().Get("user")
+		listener = r.URL.Query().Get("listener")
+	)
+
+	if user == "" {
+		http.Error(w, "user parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	for _, ns := range api.servers {
+		if listener == "" || listener == ns.name {
+			n += ns.s.KillUser(user)
+		}
+	}
+
+	reply(w, map[string]int{"killed": n})
+}
+
+// reply writes the value as JSON response.
+func reply(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Debug("failed to write admin API response", "error", err)
+	}
+}
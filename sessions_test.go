@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/metrics"
+	"github.com/z0rr0/gsocks5/server"
+)
+
+const timeout = 2 * time.Second
+
+// listenTarget starts a destination server that keeps connections open until the test end.
+func listenTarget(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			c, e := listener.Accept()
+			if e != nil {
+				return
+			}
+			t.Cleanup(func() { _ = c.Close() })
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// startServer starts SOCKS5 server with users alice and bob and returns its address.
+func startServer(t *testing.T) (*server.Server, string) {
+	cfg := &socks5.Config{Credentials: socks5.StaticCredentials{"alice": "secret", "bob": "secret"}}
+
+	s, err := server.New(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := &server.Params{
+		Addr:        listener.Addr().String(),
+		Listener:    listener,
+		Connections: 4,
+		Done:        make(chan struct{}),
+		Sigint:      make(chan os.Signal),
+		Drain:       timeout,
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if e := s.ListenAndServe(params); e != nil {
+			t.Error(e)
+		}
+	}()
+
+	<-params.Done
+	t.Cleanup(func() {
+		params.Sigint <- os.Interrupt
+		<-stopped
+	})
+
+	return s, params.Addr
+}
+
+// dialAs opens a session of the user to the target.
+func dialAs(t *testing.T, addr, user, target string) net.Conn {
+	dialer, err := proxy.SOCKS5("tcp", addr, &proxy.Auth{User: user, Password: "secret"}, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c
+}
+
+// call sends the admin API request with credentials and returns the response status and decoded body.
+func call(t *testing.T, h http.Handler, method, target, password string, result any) int {
+	r := httptest.NewRequest(method, target, nil)
+	if password != "" {
+		r.SetBasicAuth("admin", password)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if result != nil && w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return w.Code
+}
+
+func TestSessionsAPI(t *testing.T) {
+	var (
+		target  = listenTarget(t)
+		s, addr = startServer(t)
+		api     = &sessionsAPI{
+			servers:     []namedServer{{name: "main", s: s}},
+			credentials: socks5.StaticCredentials{"admin": "secret"},
+		}
+		h = newAdminServer("", metrics.NewRegistry(), nil, api).Handler
+	)
+
+	alice := dialAs(t, addr, "alice", target)
+	dialAs(t, addr, "bob", target)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		for _, password := range []string{"", "bad"} {
+			r := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+			if password != "" {
+				r.SetBasicAuth("admin", password)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("unexpected response for password %q: %d %v", password, w.Code, w.Header())
+			}
+		}
+
+		if code := call(t, h, http.MethodDelete, "/sessions/main/1", "bad", nil); code != http.StatusUnauthorized {
+			t.Errorf("unexpected kill status %d", code)
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		testCases := []struct {
+			query string
+			users []string
+		}{
+			{query: "", users: []string{"alice", "bob"}},
+			{query: "?user=alice", users: []string{"alice"}},
+			{query: "?listener=main&client=127.0.0.1", users: []string{"alice", "bob"}},
+			{query: "?listener=other"},
+			{query: "?client=127.0.0.2"},
+			{query: "?destination=" + target, users: []string{"alice", "bob"}},
+			{query: "?destination=example.com"},
+			{query: "?idle=1h"},
+		}
+
+		for _, tc := range testCases {
+			var views []sessionView
+			if code := call(t, h, http.MethodGet, "/sessions"+tc.query, "secret", &views); code != http.StatusOK {
+				t.Errorf("unexpected status %d for %q", code, tc.query)
+				continue
+			}
+
+			users := make(map[string]bool, len(views))
+			for _, v := range views {
+				users[v.User] = v.Listener == "main" && v.Destination == target
+			}
+
+			if len(users) != len(tc.users) {
+				t.Errorf("unexpected sessions for %q: %+v", tc.query, views)
+			}
+
+			for _, user := range tc.users {
+				if !users[user] {
+					t.Errorf("expected session of %s for %q: %+v", user, tc.query, views)
+				}
+			}
+		}
+
+		if code := call(t, h, http.MethodGet, "/sessions?idle=bad", "secret", nil); code != http.StatusBadRequest {
+			t.Errorf("unexpected status %d for invalid idle time", code)
+		}
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		var views []sessionView
+		if code := call(t, h, http.MethodGet, "/sessions?user=alice", "secret", &views); code != http.StatusOK {
+			t.Fatalf("unexpected status %d", code)
+		}
+
+		if n := len(views); n != 1 {
+			t.Fatalf("expected 1 session, got %d", n)
+		}
+
+		cases := []struct {
+			target string
+			code   int
+			killed int
+		}{
+			{target: "/sessions/main/" + views[0].ID, code: http.StatusOK, killed: 1},
+			{target: "/sessions/main/unknown", code: http.StatusNotFound},
+			{target: "/sessions/other/1", code: http.StatusNotFound},
+			{target: "/sessions", code: http.StatusBadRequest},
+			{target: "/sessions?user=bob&listener=other", code: http.StatusOK},
+			{target: "/sessions?user=bob", code: http.StatusOK, killed: 1},
+		}
+
+		for _, c := range cases {
+			var result map[string]int
+			if code := call(t, h, http.MethodDelete, c.target, "secret", &result); code != c.code {
+				t.Errorf("unexpected status %d for %s", code, c.target)
+			}
+
+			if result["killed"] != c.killed {
+				t.Errorf("unexpected result %v for %s", result, c.target)
+			}
+		}
+
+		if err := alice.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := alice.Read(make([]byte, 1)); err == nil {
+			t.Error("expected closed connection")
+		}
+
+		// killed sessions are removed by their handlers
+		deadline := time.Now().Add(timeout)
+		for len(s.ListSessions()) > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+
+		if n := len(s.ListSessions()); n != 0 {
+			t.Errorf("unexpected active sessions %d", n)
+		}
+	})
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// upgradeSignals are not supported on this platform.
+var upgradeSignals []os.Signal
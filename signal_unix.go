@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals start a new process with the same listener.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
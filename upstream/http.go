@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// ErrConnect is returned when HTTP proxy rejects CONNECT request.
+var ErrConnect = errors.New("HTTP CONNECT failed")
+
+// httpDialer is a dialer that connects through HTTP proxy by CONNECT method.
+type httpDialer struct {
+	address string
+	auth    string // value of Proxy-Authorization header
+	forward proxy.ContextDialer
+	timeout time.Duration // limit of connection and CONNECT request, zero uses only the context deadline
+}
+
+// newHTTPDialer returns a new HTTP CONNECT dialer for the proxy address and optional user credentials.
+func newHTTPDialer(address string, user *url.Userinfo, forward proxy.ContextDialer, timeout time.Duration) *httpDialer {
+	d := &httpDialer{address: address, forward: forward, timeout: timeout}
+
+	if user != nil {
+		password, _ := user.Password()
+		credentials := user.Username() + ":" + password
+		d.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	return d
+}
+
+// Dial connects to the address through the proxy.
+func (d *httpDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address through the proxy with the context.
+// CONNECT request and response are limited by the context deadline or the dialer timeout.
+func (d *httpDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	c, err := d.forward.DialContext(ctx, network, d.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", d.address, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = c.SetDeadline(deadline); err != nil {
+			return nil, errors.Join(err, c.Close())
+		}
+	}
+
+	reader, err := d.connect(c, addr)
+	if err != nil {
+		return nil, errors.Join(err, c.Close())
+	}
+
+	if err = c.SetDeadline(time.Time{}); err != nil {
+		return nil, errors.Join(err, c.Close())
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: c, reader: reader}, nil
+	}
+
+	return c, nil
+}
+
+// connect sends CONNECT request and reads the response.
+func (d *httpDialer) connect(c net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	if d.auth != "" {
+		req.Header.Set("Proxy-Authorization", d.auth)
+	}
+
+	if err := req.Write(c); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT request to %s: %w", d.address, err)
+	}
+
+	reader := bufio.NewReader(c)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT response from %s: %w", d.address, err)
+	}
+
+	if err = resp.Body.Close(); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.Join(ErrConnect, fmt.Errorf("proxy %s responded %q", d.address, resp.Status))
+		if resp.StatusCode != http.StatusProxyAuthRequired {
+			err = errors.Join(ErrDestination, err) // the proxy works, but it can not connect to the destination
+		}
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// bufferedConn is a connection with data that was read into the buffer with HTTP response.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads data from the buffer and then from the connection.
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// CloseWrite closes the write side of the connection if it is supported.
+func (c *bufferedConn) CloseWrite() error {
+	return conn.CloseWrite(c.Conn)
+}
@@ -0,0 +1,216 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// listen starts a TCP server on a random local port and handles connections by the handler.
+func listen(t *testing.T, handler func(net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if e := listener.Close(); e != nil {
+			t.Error(e)
+		}
+	})
+
+	go func() {
+		for {
+			c, e := listener.Accept()
+			if e != nil {
+				return
+			}
+			go handler(c)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// echoServer starts a TCP server that writes back all read data.
+func echoServer(t *testing.T) string {
+	return listen(t, func(c net.Conn) {
+		defer c.Close()
+		_, _ = io.Copy(c, c)
+	})
+}
+
+// eofServer starts a TCP server that writes back all data after the client's end of stream.
+func eofServer(t *testing.T) string {
+	return listen(t, func(c net.Conn) {
+		defer c.Close()
+
+		if data, err := io.ReadAll(c); err == nil {
+			_, _ = c.Write(data)
+		}
+	})
+}
+
+// silentServer starts a TCP server that accepts connections and never replies.
+func silentServer(t *testing.T) string {
+	return listen(t, func(c net.Conn) {
+		defer c.Close()
+		_, _ = io.Copy(io.Discard, c)
+	})
+}
+
+// httpProxy starts HTTP proxy that supports only CONNECT method with optional basic authentication.
+func httpProxy(t *testing.T, user, password string) string {
+	return listen(t, func(c net.Conn) {
+		defer c.Close()
+
+		reader := bufio.NewReader(c)
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		if user != "" {
+			r := &http.Request{Header: http.Header{"Authorization": req.Header.Values("Proxy-Authorization")}}
+			if u, p, ok := r.BasicAuth(); !ok || u != user || p != password {
+				_, _ = io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+				return
+			}
+		}
+
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			_, _ = io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer target.Close()
+
+		if _, err = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return
+		}
+
+		go func() {
+			_, _ = io.Copy(target, reader)
+			_ = conn.CloseWrite(target) // pass the client's end of stream
+		}()
+		_, _ = io.Copy(c, target)
+	})
+}
+
+// checkEcho writes a message to the connection and checks that the same is read back.
+func checkEcho(t *testing.T, c net.Conn) {
+	const msg = "hello"
+
+	if _, err := io.WriteString(c, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := string(buf); s != msg {
+		t.Errorf("expected %q, got %q", msg, s)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// checkHalfClose writes a message, closes the write side of the connection and checks the message is read back.
+func checkHalfClose(t *testing.T, c net.Conn) {
+	const msg = "ping"
+
+	defer func() { _ = c.Close() }()
+
+	if _, err := io.WriteString(c, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.CloseWrite(c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("expected end of stream, got %v", err)
+	}
+
+	if s := string(data); s != msg {
+		t.Errorf("expected %q, got %q", msg, s)
+	}
+}
+
+func TestHTTPDialer(t *testing.T) {
+	var (
+		echo     = echoServer(t)
+		proxyURL = httpProxy(t, "user", "secret")
+		ctx      = context.Background()
+	)
+
+	d := newHTTPDialer(proxyURL, url.UserPassword("user", "secret"), &net.Dialer{Timeout: timeout}, timeout)
+	c, err := d.Dial("tcp", echo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkEcho(t, c)
+
+	d = newHTTPDialer(proxyURL, url.UserPassword("user", "bad"), &net.Dialer{Timeout: timeout}, timeout)
+	if _, err = d.DialContext(ctx, "tcp", echo); !errors.Is(err, ErrConnect) {
+		t.Errorf("expected ErrConnect, got %v", err)
+	}
+
+	d = newHTTPDialer("127.0.0.1:1", nil, &net.Dialer{Timeout: timeout}, timeout)
+	if _, err = d.DialContext(ctx, "tcp", echo); err == nil {
+		t.Error("expected error for unavailable proxy")
+	}
+
+	// the destination data is sent with the response, it is read from the buffer
+	early := listen(t, func(c net.Conn) {
+		defer c.Close()
+
+		if _, e := http.ReadRequest(bufio.NewReader(c)); e != nil {
+			return
+		}
+
+		if _, e := io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\nhello"); e != nil {
+			return
+		}
+
+		if data, e := io.ReadAll(c); e == nil {
+			_, _ = c.Write(data)
+		}
+	})
+
+	d = newHTTPDialer(early, nil, &net.Dialer{Timeout: timeout}, timeout)
+	if c, err = d.DialContext(ctx, "tcp", echo); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(c, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("unexpected buffered data %q: %v", buf, err)
+	}
+	checkHalfClose(t, c)
+
+	// the proxy accepts the connection, but does not reply to CONNECT request
+	d = newHTTPDialer(silentServer(t), nil, &net.Dialer{}, timeout/10)
+	if _, err = d.DialContext(ctx, "tcp", echo); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
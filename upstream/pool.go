@@ -0,0 +1,271 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+const (
+	// maxFails is a number of consecutive dial errors to eject a pool member.
+	maxFails = 3
+	// ejectPeriod is a time while an ejected member is not used if there are no health checks.
+	ejectPeriod = 30 * time.Second
+	// virtualNodes is a number of points per member on the consistent hash ring.
+	virtualNodes = 100
+)
+
+// Balance is a load balancing strategy of a pool.
+type Balance string
+
+// Load balancing strategies.
+const (
+	BalanceRoundRobin Balance = "round-robin"
+	BalanceLeastConn  Balance = "least-conn"
+	BalanceHashClient Balance = "hash-client"
+	BalanceHashDest   Balance = "hash-dest"
+)
+
+// target is a rule target that can dial the destination address.
+type target interface {
+	dial(ctx context.Context, network, addr string) (net.Conn, error)
+	remoteDNS() bool
+}
+
+// member is a parent proxy in a pool.
+type member struct {
+	*parent
+	active    atomic.Int64 // number of open connections
+	fails     atomic.Uint32
+	unhealthy atomic.Bool  // result of the last health check
+	ejected   atomic.Int64 // unix time in nanoseconds until the member is ejected
+}
+
+// available returns true if the member can be used for new connections.
+func (m *member) available(now time.Time) bool {
+	return !m.unhealthy.Load() && now.UnixNano() >= m.ejected.Load()
+}
+
+// countedConn is a connection that decrements active connections counter of the member on close.
+type countedConn struct {
+	net.Conn
+	once   sync.Once
+	member *member
+}
+
+// Close closes the connection.
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.member.active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// CloseWrite closes the write side of the connection if it is supported.
+func (c *countedConn) CloseWrite() error {
+	return conn.CloseWrite(c.Conn)
+}
+
+// ringPoint is a point of a member on the consistent hash ring.
+type ringPoint struct {
+	hash   uint32
+	member *member
+}
+
+// pool is a set of parent proxies with load balancing and failure detection.
+type pool struct {
+	name    string
+	balance Balance
+	members []*member
+	ring    []ringPoint
+	counter atomic.Uint64
+	logger  *slog.Logger
+}
+
+// newPool returns a new pool of the parent proxies.
+func newPool(name string, balance Balance, parents []*parent, logger *slog.Logger) (*pool, error) {
+	switch balance {
+	case BalanceRoundRobin, BalanceLeastConn, BalanceHashClient, BalanceHashDest:
+	default:
+		return nil, fmt.Errorf("unknown balance strategy %q", balance)
+	}
+
+	p := &pool{name: name, balance: balance, logger: logger}
+	for _, prt := range parents {
+		m := &member{parent: prt}
+		p.members = append(p.members, m)
+
+		for i := range virtualNodes {
+			p.ring = append(p.ring, ringPoint{hash: hashKey(prt.name + "#" + strconv.Itoa(i)), member: m})
+		}
+	}
+
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p, nil
+}
+
+// remoteDNS returns true if all members of the pool resolve requested host names themselves.
+func (p *pool) remoteDNS() bool {
+	for _, m := range p.members {
+		if !m.remote {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hashKey returns 32-bit FNV-1a hash of the key.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key)) // never returns an error
+	return h.Sum32()
+}
+
+// dial connects to the address through a selected member and tracks its failures.
+func (p *pool) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	m := p.selectMember(ctx, addr, time.Now())
+	if m == nil {
+		return nil, fmt.Errorf("no available proxy in pool %q", p.name)
+	}
+
+	c, err := m.dial(ctx, network, addr)
+	if err != nil {
+		if errors.Is(err, ErrDestination) {
+			m.fails.Store(0) // the member is available, only the destination is not
+		} else {
+			p.failed(m)
+		}
+		return nil, fmt.Errorf("pool %q proxy %q: %w", p.name, m.name, err)
+	}
+
+	m.fails.Store(0)
+	m.active.Add(1)
+
+	return &countedConn{Conn: c, member: m}, nil
+}
+
+// failed registers a dial error of the member and ejects it after maxFails consecutive errors.
+func (p *pool) failed(m *member) {
+	if m.fails.Add(1) < maxFails {
+		return
+	}
+
+	m.fails.Store(0)
+	m.ejected.Store(time.Now().Add(ejectPeriod).UnixNano())
+	p.logger.Warn("upstream proxy is ejected", "pool", p.name, "proxy", m.name, "failures", maxFails)
+}
+
+// selectMember returns an available member by the balance strategy or nil.
+func (p *pool) selectMember(ctx context.Context, addr string, now time.Time) *member {
+	switch p.balance {
+	case BalanceLeastConn:
+		return p.leastConn(now)
+	case BalanceHashClient:
+		client := conn.RequestFrom(ctx).Client
+		if host, _, err := net.SplitHostPort(client); err == nil {
+			client = host
+		}
+		return p.hashed(client, now)
+	case BalanceHashDest:
+		dest := conn.RequestFrom(ctx).FQDN
+		if dest == "" {
+			dest = addr
+		}
+		return p.hashed(dest, now)
+	default:
+		return p.roundRobin(now)
+	}
+}
+
+// roundRobin returns the next available member.
+func (p *pool) roundRobin(now time.Time) *member {
+	n := uint64(len(p.members))
+
+	for range n {
+		if m := p.members[(p.counter.Add(1)-1)%n]; m.available(now) {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// leastConn returns an available member with the minimum number of active connections.
+func (p *pool) leastConn(now time.Time) *member {
+	var result *member
+
+	for _, m := range p.members {
+		if m.available(now) && (result == nil || m.active.Load() < result.active.Load()) {
+			result = m
+		}
+	}
+
+	return result
+}
+
+// hashed returns an available member for the key by the consistent hash ring.
+func (p *pool) hashed(key string, now time.Time) *member {
+	n := len(p.ring)
+	if n == 0 {
+		return nil
+	}
+
+	h := hashKey(key)
+	start := sort.Search(n, func(i int) bool { return p.ring[i].hash >= h })
+
+	for i := range n {
+		if m := p.ring[(start+i)%n].member; m.available(now) {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// check probes all members by connection to the target address through them.
+func (p *pool) check(ctx context.Context, targetAddr string, timeout time.Duration) {
+	var wg sync.WaitGroup
+
+	for _, m := range p.members {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			p.probe(ctx, m, targetAddr, timeout)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// probe checks one member and updates its health state.
+func (p *pool) probe(ctx context.Context, m *member, targetAddr string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	c, err := m.dialer.DialContext(ctx, "tcp", targetAddr)
+	if err == nil {
+		err = c.Close()
+	}
+
+	if err != nil {
+		if !m.unhealthy.Swap(true) {
+			p.logger.Warn("upstream proxy failed health check", "pool", p.name, "proxy", m.name, "error", err)
+		}
+		return
+	}
+
+	m.ejected.Store(0)
+	if m.unhealthy.Swap(false) {
+		p.logger.Info("upstream proxy is healthy again", "pool", p.name, "proxy", m.name)
+	}
+}
@@ -0,0 +1,282 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/z0rr0/gsocks5/conn"
+)
+
+// testPool returns a pool with parents that connect to the addresses by SOCKS5.
+func testPool(t *testing.T, balance Balance, addresses ...string) *pool {
+	parents := make([]*parent, 0, len(addresses))
+
+	for i, addr := range addresses {
+		p, err := newParent(string(rune('a'+i)), "socks5://"+addr, &net.Dialer{Timeout: timeout}, timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parents = append(parents, p)
+	}
+
+	p, err := newPool("test", balance, parents, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestNewPool(t *testing.T) {
+	if _, err := newPool("test", "random", nil, logger); err == nil {
+		t.Error("expected error for unknown balance strategy")
+	}
+
+	p := testPool(t, BalanceHashDest, "127.0.0.1:1", "127.0.0.1:2")
+	if n := len(p.ring); n != 2*virtualNodes {
+		t.Errorf("expected %d ring points, got %d", 2*virtualNodes, n)
+	}
+}
+
+func TestPool_SelectMember(t *testing.T) {
+	var (
+		now = time.Now()
+		ctx = context.Background()
+	)
+
+	t.Run("roundRobin", func(t *testing.T) {
+		p := testPool(t, BalanceRoundRobin, "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3")
+		p.members[1].unhealthy.Store(true)
+
+		for _, want := range []string{"a", "c", "a", "c"} {
+			if m := p.selectMember(ctx, "", now); m.name != want {
+				t.Errorf("expected member %q, got %q", want, m.name)
+			}
+		}
+	})
+
+	t.Run("leastConn", func(t *testing.T) {
+		p := testPool(t, BalanceLeastConn, "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3")
+		p.members[0].active.Store(5)
+		p.members[1].active.Store(2)
+		p.members[2].active.Store(1)
+		p.members[2].ejected.Store(now.Add(time.Minute).UnixNano())
+
+		if m := p.selectMember(ctx, "", now); m.name != "b" {
+			t.Errorf("expected member b, got %q", m.name)
+		}
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		p := testPool(t, BalanceHashClient, "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3")
+		reqCtx := conn.WithRequest(ctx, &conn.Request{Client: "192.0.2.1:50000"})
+		first := p.selectMember(reqCtx, "", now)
+
+		// the client port does not matter
+		reqCtx = conn.WithRequest(ctx, &conn.Request{Client: "192.0.2.1:50001"})
+		if m := p.selectMember(reqCtx, "", now); m != first {
+			t.Errorf("expected the same member %q, got %q", first.name, m.name)
+		}
+
+		first.unhealthy.Store(true)
+		if m := p.selectMember(reqCtx, "", now); m == nil || m == first {
+			t.Errorf("expected another member than %q", first.name)
+		}
+	})
+
+	t.Run("noMembers", func(t *testing.T) {
+		p := testPool(t, BalanceHashDest, "127.0.0.1:1")
+		p.members[0].unhealthy.Store(true)
+
+		if m := p.selectMember(ctx, "192.0.2.1:443", now); m != nil {
+			t.Errorf("unexpected member %q", m.name)
+		}
+
+		if _, err := p.dial(ctx, "tcp", "192.0.2.1:443"); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestPool_Failures(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		echo      = echoServer(t)
+		socksAddr = socksServer(t, nil)
+	)
+
+	// the first member is not available, the second one is a working proxy
+	p := testPool(t, BalanceLeastConn, "127.0.0.1:1", socksAddr)
+	bad, good := p.members[0], p.members[1]
+	good.active.Store(1) // to select the bad member first
+
+	for range maxFails {
+		if _, err := p.dial(ctx, "tcp", echo); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+
+	if bad.available(time.Now()) {
+		t.Fatal("expected ejected member")
+	}
+
+	c, err := p.dial(ctx, "tcp", echo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := good.active.Load(); n != 2 {
+		t.Errorf("expected 2 active connections, got %d", n)
+	}
+
+	checkEcho(t, c)
+	if n := good.active.Load(); n != 1 {
+		t.Errorf("expected 1 active connection after close, got %d", n)
+	}
+
+	// health check keeps the bad member ejected and reinstates the good one
+	good.unhealthy.Store(true)
+	p.check(ctx, echo, timeout)
+
+	if !bad.unhealthy.Load() || good.unhealthy.Load() {
+		t.Errorf("unexpected health state: bad=%v, good=%v", bad.unhealthy.Load(), good.unhealthy.Load())
+	}
+}
+
+func TestPool_DestinationErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the destination refuses connections
+	refused := listener.Addr().String()
+	if err = listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var parents []*parent
+	for name, rawURL := range map[string]string{
+		"socks": "socks5://" + socksServer(t, nil),
+		"http":  "http://" + httpProxy(t, "", ""),
+	} {
+		prt, parentErr := newParent(name, rawURL, &net.Dialer{Timeout: timeout}, timeout)
+		if parentErr != nil {
+			t.Fatal(parentErr)
+		}
+		parents = append(parents, prt)
+	}
+
+	p, err := newPool("test", BalanceRoundRobin, parents, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range maxFails * len(parents) {
+		if _, err = p.dial(context.Background(), "tcp", refused); !errors.Is(err, ErrDestination) {
+			t.Fatalf("expected destination error, got %v", err)
+		}
+	}
+
+	// healthy parents are not ejected
+	for _, m := range p.members {
+		if !m.available(time.Now()) || m.fails.Load() != 0 {
+			t.Errorf("unexpected ejected member %q", m.name)
+		}
+	}
+}
+
+func TestRouter_HealthCheck(t *testing.T) {
+	var (
+		echo      = echoServer(t)
+		socksAddr = socksServer(t, nil)
+	)
+
+	config := "proxy a socks5://" + socksAddr + "\nproxy b socks5://127.0.0.1:1\n" +
+		"pool p round-robin a b\nhealth " + echo + " 1h\ndefault p\n"
+
+	r, err := newRouter(t, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		r.HealthCheck(ctx)
+		close(done)
+	}()
+
+	// the first check is done immediately
+	members := r.pools["p"].members
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline) && !members[1].unhealthy.Load(); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	if members[0].unhealthy.Load() || !members[1].unhealthy.Load() {
+		t.Errorf("unexpected health state: a=%v, b=%v", members[0].unhealthy.Load(), members[1].unhealthy.Load())
+	}
+}
+
+func TestPool_SilentParents(t *testing.T) {
+	const dialTimeout = timeout / 10
+
+	var parents []*parent
+	for name, scheme := range map[string]string{"socks": "socks5://", "http": "http://"} {
+		prt, err := newParent(name, scheme+silentServer(t), &net.Dialer{}, dialTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parents = append(parents, prt)
+	}
+
+	p, err := newPool("test", BalanceRoundRobin, parents, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the context of SOCKS requests has no deadline, the dial is limited by the parent timeout
+	for range maxFails * len(parents) {
+		start := time.Now()
+		if _, err = p.dial(context.Background(), "tcp", "192.0.2.1:80"); err == nil {
+			t.Fatal("expected error for silent parent")
+		}
+
+		if d := time.Since(start); d > timeout {
+			t.Errorf("too long dial %v", d)
+		}
+	}
+
+	// not replying parents are ejected
+	for _, m := range p.members {
+		if m.available(time.Now()) {
+			t.Errorf("member %q is not ejected", m.name)
+		}
+	}
+}
+
+func TestPool_HalfClose(t *testing.T) {
+	prt, err := newParent("a", "http://"+httpProxy(t, "", ""), &net.Dialer{Timeout: timeout}, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := newPool("test", BalanceRoundRobin, []*parent{prt}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := p.dial(context.Background(), "tcp", eofServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkHalfClose(t, c)
+}
@@ -0,0 +1,501 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/armon/go-socks5"
+	"golang.org/x/net/proxy"
+
+	"github.com/z0rr0/gsocks5/conn"
+	"github.com/z0rr0/gsocks5/internal/domain"
+	"github.com/z0rr0/gsocks5/internal/lines"
+)
+
+const (
+	// Direct is a rule target for direct connections without a parent proxy.
+	Direct = "direct"
+	// socksReplyError is a message prefix of SOCKS client errors about not succeeded replies.
+	socksReplyError = "unknown error "
+	// defaultTimeout limits connection and handshake with a parent proxy if the connection timeout is not set.
+	defaultTimeout = 30 * time.Second
+)
+
+var (
+	// ErrConfig is returned when the upstream configuration is invalid.
+	ErrConfig = errors.New("invalid upstream config")
+	// ErrDestination is returned when the parent proxy is available, but it failed to connect to the destination.
+	ErrDestination = errors.New("parent proxy failed to connect to the destination")
+)
+
+// dialer is a dialer with and without the context.
+type dialer interface {
+	proxy.Dialer
+	proxy.ContextDialer
+}
+
+// firstConnKey is a context key of the connection to the first proxy of a chain.
+type firstConnKey struct{}
+
+// firstConnDialer is a dialer that saves its connection to the context if it is the first one of a chain.
+type firstConnDialer struct {
+	dialer
+}
+
+// DialContext connects to the address and saves the connection to the context.
+func (d firstConnDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	c, err := d.dialer.DialContext(ctx, network, addr)
+	if first, ok := ctx.Value(firstConnKey{}).(*net.Conn); ok && err == nil && *first == nil {
+		*first = c
+	}
+
+	return c, err
+}
+
+// chainConn is a connection through a proxy chain.
+// Its end of stream is passed by the connection to the first proxy, SOCKS5 connections do not support it.
+type chainConn struct {
+	net.Conn
+	first net.Conn
+}
+
+// CloseWrite closes the write side of the connection to the first proxy.
+func (c *chainConn) CloseWrite() error {
+	return conn.CloseWrite(c.first)
+}
+
+// parent is a configured parent proxy or a chain of proxies.
+type parent struct {
+	name    string
+	remote  bool // pass requested host names to the parent proxy instead of resolved IP addresses
+	dialer  dialer
+	timeout time.Duration // limit of connection and handshake with the proxy
+}
+
+// dial connects to the address through the parent proxy.
+// The context of SOCKS requests has no deadline, so the dial is always limited by the parent timeout.
+func (p *parent) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var first net.Conn
+
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, firstConnKey{}, &first), p.timeout)
+	defer cancel()
+
+	if fqdn := conn.RequestFrom(ctx).FQDN; p.remote && fqdn != "" {
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			addr = net.JoinHostPort(fqdn, port)
+		}
+	}
+
+	c, err := p.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		if socksReplyFailed(err) {
+			return nil, errors.Join(ErrDestination, err)
+		}
+		return nil, err
+	}
+
+	if _, ok := c.(interface{ CloseWrite() error }); !ok && first != nil {
+		return &chainConn{Conn: c, first: first}, nil
+	}
+
+	return c, nil
+}
+
+// socksReplyFailed returns true if the error is a not succeeded reply of SOCKS parent proxy.
+// Only the top-level error is checked without unwrapping,
+// because errors of chained proxies are wrapped by the parent one and they are not its replies.
+func socksReplyFailed(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Err != nil && strings.HasPrefix(opErr.Err.Error(), socksReplyError)
+}
+
+// remoteDNS returns true if the parent proxy resolves requested host names itself.
+func (p *parent) remoteDNS() bool {
+	return p.remote
+}
+
+// rule is a parent proxy selection rule, only one of match fields is set.
+type rule struct {
+	suffix  string
+	network *net.IPNet
+	user    string
+	name    string // target name
+	target  target // nil for direct connections
+}
+
+// match returns true if the request matches the rule.
+func (r *rule) match(req *conn.Request, ip net.IP) bool {
+	switch {
+	case r.suffix != "":
+		return req.FQDN != "" && domain.MatchSuffix(domain.Normalize(req.FQDN), r.suffix)
+	case r.network != nil:
+		return ip != nil && r.network.Contains(ip)
+	case r.user != "":
+		return req.User == r.user
+	default:
+		return true
+	}
+}
+
+// Router selects parent proxies and pools for requests by rules.
+type Router struct {
+	parents      map[string]*parent
+	pools        map[string]*pool
+	rules        []rule
+	healthTarget string
+	healthPeriod time.Duration
+	logger       *slog.Logger
+}
+
+// New returns a new Router from the configuration file.
+// The dialer is used for connections to the first proxy in a chain.
+func New(fileName string, d *net.Dialer, logger *slog.Logger) (*Router, error) {
+	r := &Router{
+		parents: make(map[string]*parent),
+		pools:   make(map[string]*pool),
+		logger:  logger,
+	}
+
+	err := lines.Read(fileName, func(fields []string) error { return r.parse(fields, d) })
+	if err != nil {
+		return nil, errors.Join(ErrConfig, err)
+	}
+
+	logger.Info("upstream proxies loaded", "proxies", len(r.parents), "pools", len(r.pools), "rules", len(r.rules))
+	return r, nil
+}
+
+// parse parses fields of configuration lines:
+//
+//	proxy NAME URL [via NAME]
+//	pool NAME round-robin|least-conn|hash-client|hash-dest PROXY [PROXY...]
+//	health HOST:PORT INTERVAL
+//	rule domain|cidr|user VALUE NAME|direct
+//	default NAME|direct
+func (r *Router) parse(fields []string, d *net.Dialer) error {
+	switch fields[0] {
+	case "proxy":
+		return r.parseProxy(fields[1:], d)
+	case "pool":
+		return r.parsePool(fields[1:])
+	case "health":
+		return r.parseHealth(fields[1:])
+	case "rule":
+		return r.parseRule(fields[1:])
+	case "default":
+		return r.parseDefault(fields[1:])
+	default:
+		return fmt.Errorf("unknown directive %q", fields[0])
+	}
+}
+
+// parseProxy parses "NAME URL [via NAME]" fields.
+func (r *Router) parseProxy(fields []string, d *net.Dialer) error {
+	var forward dialer = d
+
+	switch {
+	case len(fields) == 4 && fields[2] == "via":
+		via, ok := r.parents[fields[3]]
+		if !ok {
+			return fmt.Errorf("unknown proxy %q, it should be defined before usage", fields[3])
+		}
+		forward = via.dialer
+	case len(fields) != 2:
+		return fmt.Errorf("expected proxy name, URL and optional via part, got %q", strings.Join(fields, " "))
+	}
+
+	name := fields[0]
+	if err := r.checkName(name); err != nil {
+		return err
+	}
+
+	p, err := newParent(name, fields[1], forward, d.Timeout)
+	if err != nil {
+		return err
+	}
+
+	r.parents[name] = p
+	return nil
+}
+
+// newParent returns a new parent proxy from URL "scheme://[user:password@]host:port[?dns=remote|local]".
+// Supported schemes are socks5, socks5h (with remote DNS by default) and http.
+// The timeout limits connection and handshake with the proxy, defaultTimeout is used if it is not set.
+func newParent(name, rawURL string, forward dialer, timeout time.Duration) (*parent, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	if _, _, err = net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("invalid proxy address %q: %w", u.Host, err)
+	}
+
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	forward = firstConnDialer{forward} // the first connection of a chain is saved to pass end of stream
+
+	p := &parent{name: name, remote: u.Scheme == "socks5h", timeout: timeout}
+	switch dnsMode := u.Query().Get("dns"); dnsMode {
+	case "remote":
+		p.remote = true
+	case "local":
+		p.remote = false
+	case "":
+	default:
+		return nil, fmt.Errorf("invalid dns mode %q", dnsMode)
+	}
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+
+		socksDialer, socksErr := proxy.SOCKS5("tcp", u.Host, auth, forward)
+		if socksErr != nil {
+			return nil, socksErr
+		}
+
+		p.dialer = socksDialer.(dialer)
+	case "http":
+		p.dialer = newHTTPDialer(u.Host, u.User, forward, timeout)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	return p, nil
+}
+
+// parseRule parses "domain|cidr|user VALUE TARGET" fields.
+func (r *Router) parseRule(fields []string) error {
+	if len(fields) != 3 {
+		return fmt.Errorf("expected rule type, value and target, got %q", strings.Join(fields, " "))
+	}
+
+	dest, err := r.target(fields[2])
+	if err != nil {
+		return err
+	}
+
+	rl := rule{name: fields[2], target: dest}
+	switch kind, value := fields[0], fields[1]; kind {
+	case "domain":
+		rl.suffix = domain.ParseSuffix(value)
+		if rl.suffix == "" {
+			return fmt.Errorf("empty domain suffix")
+		}
+	case "cidr":
+		if _, rl.network, err = net.ParseCIDR(value); err != nil {
+			return err
+		}
+	case "user":
+		rl.user = value
+	default:
+		return fmt.Errorf("unknown rule type %q", kind)
+	}
+
+	r.rules = append(r.rules, rl)
+	return nil
+}
+
+// parseDefault parses "TARGET" field of the rule for all requests.
+func (r *Router) parseDefault(fields []string) error {
+	if len(fields) != 1 {
+		return fmt.Errorf("expected one default target, got %q", strings.Join(fields, " "))
+	}
+
+	dest, err := r.target(fields[0])
+	if err != nil {
+		return err
+	}
+
+	r.rules = append(r.rules, rule{name: fields[0], target: dest})
+	return nil
+}
+
+// parsePool parses "NAME BALANCE PROXY [PROXY...]" fields.
+func (r *Router) parsePool(fields []string) error {
+	if len(fields) < 3 {
+		return fmt.Errorf("expected pool name, balance strategy and proxies, got %q", strings.Join(fields, " "))
+	}
+
+	name := fields[0]
+	if err := r.checkName(name); err != nil {
+		return err
+	}
+
+	parents := make([]*parent, 0, len(fields)-2)
+	for _, proxyName := range fields[2:] {
+		p, ok := r.parents[proxyName]
+		if !ok {
+			return fmt.Errorf("unknown proxy %q, it should be defined before usage", proxyName)
+		}
+		parents = append(parents, p)
+	}
+
+	p, err := newPool(name, Balance(fields[1]), parents, r.logger)
+	if err != nil {
+		return err
+	}
+
+	r.pools[name] = p
+	return nil
+}
+
+// parseHealth parses "HOST:PORT INTERVAL" fields of pools health checks.
+func (r *Router) parseHealth(fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("expected health check target and interval, got %q", strings.Join(fields, " "))
+	}
+
+	if _, _, err := net.SplitHostPort(fields[0]); err != nil {
+		return fmt.Errorf("invalid health check target %q: %w", fields[0], err)
+	}
+
+	interval, err := time.ParseDuration(fields[1])
+	if err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		return fmt.Errorf("health check interval should be positive")
+	}
+
+	r.healthTarget, r.healthPeriod = fields[0], interval
+	return nil
+}
+
+// checkName returns an error if the proxy or pool name is already used.
+func (r *Router) checkName(name string) error {
+	_, isProxy := r.parents[name]
+	_, isPool := r.pools[name]
+
+	if isProxy || isPool || name == Direct {
+		return fmt.Errorf("duplicate proxy name %q", name)
+	}
+
+	return nil
+}
+
+// target returns a parent proxy or pool by name or nil for direct connections.
+func (r *Router) target(name string) (target, error) {
+	if name == Direct {
+		return nil, nil
+	}
+
+	if p, ok := r.pools[name]; ok {
+		return p, nil
+	}
+
+	if p, ok := r.parents[name]; ok {
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("unknown proxy %q", name)
+}
+
+// HealthCheck probes pools members every health check interval until the context is done.
+// It does nothing if there are no pools or health checks are not configured.
+func (r *Router) HealthCheck(ctx context.Context) {
+	if r.healthPeriod == 0 || len(r.pools) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.healthPeriod)
+	defer ticker.Stop()
+
+	for {
+		for _, p := range r.pools {
+			p.check(ctx, r.healthTarget, r.healthPeriod)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Forward returns a dial function of the first parent proxy whose rule matches the request.
+// It returns nil for direct connections.
+func (r *Router) Forward(ctx context.Context, addr string) conn.DialType {
+	var (
+		req = conn.RequestFrom(ctx)
+		ip  net.IP
+	)
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip = net.ParseIP(host)
+	}
+
+	for i := range r.rules {
+		if rl := &r.rules[i]; rl.match(req, ip) {
+			if rl.target == nil {
+				return nil
+			}
+
+			r.logger.Debug(
+				"connection goes via upstream",
+				"client", req.Client, "user", req.User, "dest", addr, "fqdn", req.FQDN, "upstream", rl.name,
+			)
+			return rl.target.dial
+		}
+	}
+
+	return nil
+}
+
+// Resolver returns a name resolver that does not resolve names routed to parent proxies with remote DNS,
+// socks5.Server dials such destinations by the name. Other names are resolved by the next resolver.
+func (r *Router) Resolver(next socks5.NameResolver) socks5.NameResolver {
+	return &remoteResolver{router: r, next: next}
+}
+
+// remote returns true if the name is routed to a parent proxy with remote DNS.
+// Rules by network and user can not be checked before the name resolving, so they stop the search.
+func (r *Router) remote(name string) bool {
+	req := &conn.Request{FQDN: name}
+
+	for i := range r.rules {
+		rl := &r.rules[i]
+
+		switch {
+		case rl.network != nil || rl.user != "":
+			return false
+		case rl.match(req, nil):
+			return rl.target != nil && rl.target.remoteDNS()
+		}
+	}
+
+	return false
+}
+
+// remoteResolver is a name resolver that skips lookups of names resolved by parent proxies.
+type remoteResolver struct {
+	router *Router
+	next   socks5.NameResolver
+}
+
+// Resolve returns nil IP address for names routed to parent proxies with remote DNS,
+// so they do not leak to the local resolver.
+func (rr *remoteResolver) Resolve(ctx context.Context, name string) (context.Context, net.IP, error) {
+	if rr.router.remote(name) {
+		rr.router.logger.Debug("name is resolved by upstream", "dest", name)
+		return ctx, nil, nil
+	}
+
+	return rr.next.Resolve(ctx, name)
+}